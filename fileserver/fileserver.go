@@ -5,6 +5,7 @@ package fileserver
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime"
 	"os"
@@ -27,27 +28,30 @@ func Serve(root string, autoindex bool) func(w gemini.ResponseWriter, r *gemini.
 			if errors.Is(err, ErrDirWithoutIndexFile) && autoindex {
 				body, mimeType, err := listDirectory(fullpath, r.URL.Path)
 				if err != nil {
-					w.WriteHeader(gemini.StatusNotFound, err.Error())
+					gemini.NotFound(w)
 					return
 				}
 
-				w.WriteHeader(gemini.StatusSuccess, mimeType)
+				gemini.Success(w, mimeType)
 				w.Write(body)
 				return
 			}
 
-			w.WriteHeader(gemini.StatusNotFound, err.Error())
+			// the error text names paths on disk, don't pass it to the client.
+			gemini.NotFound(w)
 			return
 		}
 
-		body, mimeType, err := readFile(fullpath)
+		file, mimeType, err := openFile(fullpath)
 		if err != nil {
-			w.WriteHeader(gemini.StatusNotFound, err.Error())
+			gemini.NotFound(w)
 			return
 		}
+		defer file.Close()
 
-		w.WriteHeader(gemini.StatusSuccess, mimeType)
-		w.Write(body)
+		// stream the file, the response writer may implement io.ReaderFrom
+		gemini.Success(w, mimeType)
+		io.Copy(w, file)
 	}
 }
 
@@ -71,7 +75,7 @@ func fullPath(root, requestPath string) (string, error) {
 	return fullpath, nil
 }
 
-func readFile(filepath string) ([]byte, string, error) {
+func openFile(filepath string) (*os.File, string, error) {
 	mimeType := getMimeType(filepath)
 	if mimeType == "" {
 		return nil, "", ErrUnsupportedFileType
@@ -81,13 +85,8 @@ func readFile(filepath string) ([]byte, string, error) {
 	if err != nil {
 		return nil, "", fmt.Errorf("file: %w", err)
 	}
-	defer file.Close()
 
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
-		return nil, "", fmt.Errorf("read: %w", err)
-	}
-	return data, mimeType, nil
+	return file, mimeType, nil
 }
 
 func getMimeType(fullpath string) string {
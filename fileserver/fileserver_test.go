@@ -0,0 +1,49 @@
+package fileserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+func TestServe(t *testing.T) {
+	root, err := ioutil.TempDir("", "fileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := ioutil.WriteFile(filepath.Join(root, "index.gmi"), []byte("# home\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path      string
+		autoindex bool
+		header    string
+		body      string
+	}{
+		{"/", false, "20 " + gemini.MimeType, "# home\n"},
+		{"/index.gmi", false, "20 " + gemini.MimeType, "# home\n"},
+		{"/missing.gmi", false, "51 not found", ""},
+		{"/dir", false, "51 not found", ""},
+		{"/dir", true, "20 " + gemini.MimeType, "Index of /dir/\n\n=> / ..\n"},
+	}
+
+	for _, tt := range tests {
+		rec := geminitest.NewRecorder()
+		handler := gemini.HandlerFunc(Serve(root, tt.autoindex))
+		handler.ServeGemini(rec, geminitest.NewRequest("gemini://localhost"+tt.path))
+
+		if rec.Header() != tt.header || rec.Body.String() != tt.body {
+			t.Errorf("%s: got %q %q, want %q %q", tt.path, rec.Header(), rec.Body.String(), tt.header, tt.body)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package fileserver
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+var (
+	ErrUploadTooLarge    = errors.New("upload exceeds size limit")
+	ErrUploadIncomplete  = errors.New("upload shorter than announced size")
+	ErrMimeTypeForbidden = errors.New("mime type not allowed")
+)
+
+// UploadConfig restricts titan uploads.
+type UploadConfig struct {
+	// MaxSize is the maximum upload size in bytes, zero means no limit.
+	MaxSize int64
+
+	// MimeTypes lists the allowed media types, e.g. "text/gemini". Both the announced type and
+	// the type derived from the file extension must be allowed. Empty allows any type that is
+	// served.
+	MimeTypes []string
+
+	// Tokens and Fingerprints authorize uploads by titan token or by the SHA-256 fingerprint
+	// of the client certificate. Uploads are refused if both are empty.
+	Tokens       []string
+	Fingerprints []string
+}
+
+// Upload returns a handler for titan requests that writes uploads below root. Files are
+// replaced atomically, an upload of size zero deletes the file. Successful uploads are
+// redirected to the gemini URL of the resource.
+func Upload(root string, cfg UploadConfig) func(w gemini.ResponseWriter, r *gemini.Request) {
+	return func(w gemini.ResponseWriter, r *gemini.Request) {
+		if r.Titan == nil {
+			w.WriteHeader(gemini.StatusBadRequest, "titan request expected")
+			return
+		}
+
+		if code, ok := cfg.authorize(r); !ok {
+			w.WriteHeader(code, "upload not authorized")
+			return
+		}
+
+		reqpath := r.URL.Path
+		if strings.HasSuffix(reqpath, "/") {
+			reqpath = path.Join(reqpath, gemini.IndexFile)
+		}
+		fullpath := filepath.Join(root, filepath.FromSlash(path.Clean(reqpath)))
+
+		if r.Titan.Size == 0 {
+			// only files are deleted, os.Remove would take empty directories as well.
+			if info, err := os.Stat(fullpath); err != nil || info.IsDir() || os.Remove(fullpath) != nil {
+				gemini.NotFound(w)
+				return
+			}
+			gemini.Redirect(w, geminiURL(r, strings.TrimSuffix(path.Dir(reqpath), "/")+"/"))
+			return
+		}
+
+		if cfg.MaxSize > 0 && r.Titan.Size > cfg.MaxSize {
+			w.WriteHeader(gemini.StatusPermanentFailure, ErrUploadTooLarge.Error())
+			return
+		}
+
+		if !cfg.allowed(r.Titan.Mime) || !cfg.allowed(getMimeType(fullpath)) {
+			w.WriteHeader(gemini.StatusPermanentFailure, ErrMimeTypeForbidden.Error())
+			return
+		}
+
+		if err := writeFile(fullpath, r.Body, r.Titan.Size); err != nil {
+			if errors.Is(err, ErrUploadIncomplete) {
+				w.WriteHeader(gemini.StatusBadRequest, err.Error())
+				return
+			}
+			// the error text names paths on disk, don't pass it to the client.
+			w.WriteHeader(gemini.StatusTemporaryFailure, "upload failed")
+			return
+		}
+
+		gemini.Redirect(w, geminiURL(r, reqpath))
+	}
+}
+
+// authorize checks the titan token and the client certificate, the status code is returned
+// for refused uploads.
+func (cfg UploadConfig) authorize(r *gemini.Request) (int, bool) {
+	if token := r.Titan.Token; token != "" {
+		for _, t := range cfg.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return 0, true
+			}
+		}
+	}
+
+	fingerprint := r.Fingerprint()
+	if fingerprint == "" {
+		if r.Titan.Token != "" {
+			return gemini.StatusCertificateNotAuthorized, false
+		}
+		return gemini.StatusClientCertificateRequired, false
+	}
+
+	for _, fp := range cfg.Fingerprints {
+		if strings.EqualFold(strings.ReplaceAll(fp, ":", ""), fingerprint) {
+			return 0, true
+		}
+	}
+	return gemini.StatusCertificateNotAuthorized, false
+}
+
+func (cfg UploadConfig) allowed(mimeType string) bool {
+	if mimeType == "" {
+		return false
+	} else if len(cfg.MimeTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	for _, mt := range cfg.MimeTypes {
+		if strings.EqualFold(mt, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFile writes exactly size bytes from r to a temporary file next to fullpath and renames it
+// into place, readers never see a partial file.
+func writeFile(fullpath string, r io.Reader, size int64) error {
+	dir := filepath.Dir(fullpath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	} else if n != size {
+		return ErrUploadIncomplete
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), fullpath); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	return nil
+}
+
+// geminiURL returns the gemini URL of p on the host the upload was sent to.
+func geminiURL(r *gemini.Request, p string) string {
+	u := *r.URL
+	u.Scheme = "gemini"
+	u.Path, u.RawPath, u.RawQuery = p, "", ""
+	return u.String()
+}
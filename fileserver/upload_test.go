@@ -0,0 +1,89 @@
+package fileserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+// titan sends a raw titan request, the client has no titan support, and returns the header.
+func titan(t *testing.T, ts *geminitest.Server, request string) string {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(header, gemini.Termination)
+}
+
+func TestUpload(t *testing.T) {
+	root, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	store := Upload(root, UploadConfig{MaxSize: 16, MimeTypes: []string{"text/gemini"}, Tokens: []string{"secret"}})
+	ts := geminitest.NewUnstartedServer(gemini.HandlerFunc(store))
+	ts.Config.Titan = true
+	ts.Start()
+	defer ts.Close()
+
+	host := strings.TrimPrefix(ts.URL, "gemini://")
+	tests := []struct {
+		name    string
+		request string
+		header  string
+		file    string
+		content string // expected content of file, empty if it must not exist
+	}{
+		{"no token", "titan://" + host + "/a.gmi;size=2\r\nhi", "60 upload not authorized", "a.gmi", ""},
+		{"wrong token", "titan://" + host + "/a.gmi;size=2;token=guess\r\nhi", "61 upload not authorized", "a.gmi", ""},
+		{"too large", "titan://" + host + "/a.gmi;size=17;token=secret\r\n", "50 " + ErrUploadTooLarge.Error(), "a.gmi", ""},
+		{"mime", "titan://" + host + "/a.gmi;size=2;mime=text/html;token=secret\r\nhi", "50 " + ErrMimeTypeForbidden.Error(), "a.gmi", ""},
+		{"extension", "titan://" + host + "/a.html;size=2;token=secret\r\nhi", "50 " + ErrMimeTypeForbidden.Error(), "a.html", ""},
+		{"upload", "titan://" + host + "/a.gmi;size=2;token=secret\r\nhi", "30 " + ts.URL + "/a.gmi", "a.gmi", "hi"},
+		{"replace", "titan://" + host + "/a.gmi;size=5;token=secret\r\nhello", "30 " + ts.URL + "/a.gmi", "a.gmi", "hello"},
+		{"index", "titan://" + host + "/sub/;size=2;token=secret\r\n# ", "30 " + ts.URL + "/sub/index.gmi", "sub/index.gmi", "# "},
+		{"delete", "titan://" + host + "/a.gmi;size=0;token=secret\r\n", "30 " + ts.URL + "/", "a.gmi", ""},
+		{"delete missing", "titan://" + host + "/a.gmi;size=0;token=secret\r\n", "51 not found", "a.gmi", ""},
+		{"delete directory", "titan://" + host + "/sub;size=0;token=secret\r\n", "51 not found", "sub", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if header := titan(t, ts, tt.request); header != tt.header {
+				t.Errorf("got header %q, want %q", header, tt.header)
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(root, tt.file))
+			if tt.content == "" {
+				if err == nil {
+					t.Errorf("unexpected file %s", tt.file)
+				}
+				return
+			}
+			if string(content) != tt.content {
+				t.Errorf("got content %q, want %q (%v)", content, tt.content, err)
+			}
+		})
+	}
+}
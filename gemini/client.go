@@ -0,0 +1,303 @@
+package gemini
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRedirects is the number of redirects a Client follows if MaxRedirects is zero.
+const DefaultMaxRedirects = 5
+
+// responseHeaderMaxBytes is <STATUS><SPACE><META><CR><LF> with the maximum META length.
+const responseHeaderMaxBytes = 2 + 1 + URLMaxBytes + len(Termination)
+
+// Response is the response of a Client request.
+type Response struct {
+	Status int
+	Meta   string
+
+	// Body streams the response body of success responses and is empty otherwise. The caller
+	// must close it.
+	Body io.ReadCloser
+
+	// Request is the request the response belongs to, the last one if redirects were followed.
+	Request *Request
+
+	// TLS holds the connection state, including the server certificate.
+	TLS *tls.ConnectionState
+}
+
+// Client fetches gemini resources. The zero value is usable, it follows up to
+// DefaultMaxRedirects redirects and does not verify server certificates.
+type Client struct {
+	// Certificates are presented to servers that request a client certificate.
+	Certificates []tls.Certificate
+
+	// KnownHosts pins server certificates on first use and rejects changed certificates until
+	// the pinned one expired. If nil, server certificates are not verified.
+	KnownHosts KnownHosts
+
+	// ConnectTimeout bounds dialing and the TLS handshake, ReadTimeout reading the response
+	// header and each read of the body. Zero means no timeout.
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+
+	// MaxRedirects is the number of redirects to follow, DefaultMaxRedirects if zero. A negative
+	// value disables following, the redirect response is returned instead.
+	MaxRedirects int
+}
+
+// NewRequest returns a client request for the absolute gemini URL.
+func NewRequest(rawurl string) (*Request, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("gemini: parse url: %w", err)
+	}
+
+	if u.Scheme != "gemini" {
+		return nil, ErrUnknownProtocol
+	} else if u.Hostname() == "" {
+		return nil, ErrInvalidHost
+	}
+
+	return &Request{URL: u, RequestURI: u.String()}, nil
+}
+
+// Get fetches the resource at rawurl.
+func (c *Client) Get(rawurl string) (*Response, error) {
+	req, err := NewRequest(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends the request and follows redirects according to MaxRedirects. The request context
+// bounds the whole exchange including reading the body.
+func (c *Client) Do(req *Request) (*Response, error) {
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = DefaultMaxRedirects
+	}
+
+	for redirects := 0; ; redirects++ {
+		rsp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if rsp.Status/10 != 3 || maxRedirects < 0 {
+			return rsp, nil
+		}
+		rsp.Body.Close()
+
+		if redirects >= maxRedirects {
+			return nil, ErrTooManyRedirects
+		}
+
+		target, err := req.URL.Parse(rsp.Meta)
+		if err != nil {
+			return nil, fmt.Errorf("gemini: redirect: %w", err)
+		} else if target.Scheme != "gemini" {
+			return nil, fmt.Errorf("gemini: redirect to %s: %w", target, ErrUnknownProtocol)
+		}
+
+		next := req.WithContext(req.Context())
+		next.URL = target
+		next.RequestURI = target.String()
+		req = next
+	}
+}
+
+func (c *Client) do(req *Request) (*Response, error) {
+	ctx := req.Context()
+	host := req.URL.Hostname()
+	port := req.URL.Port()
+	if port == "" {
+		port = strconv.Itoa(defaultPort)
+	}
+	addr := net.JoinHostPort(host, port)
+
+	conn, err := c.dial(ctx, addr, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// close the connection if the context is done before the body was closed.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	rsp, r, err := c.roundTrip(conn, req)
+	if err != nil {
+		close(done)
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	body := &responseBody{conn: conn, r: r, timeout: c.ReadTimeout, done: done}
+	if rsp.Status/10 != 2 {
+		body.Close()
+		rsp.Body = ioutil.NopCloser(strings.NewReader(""))
+		return rsp, nil
+	}
+
+	rsp.Body = body
+	return rsp, nil
+}
+
+func (c *Client) dial(ctx context.Context, addr, host string) (*tls.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.ConnectTimeout}
+	raw, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("gemini: dial: %w", err)
+	}
+
+	conn := tls.Client(raw, &tls.Config{
+		ServerName:   host,
+		Certificates: c.Certificates,
+		MinVersion:   tls.VersionTLS12,
+		// gemini servers commonly use self-signed certificates, they are verified by pinning
+		// through KnownHosts after the handshake.
+		InsecureSkipVerify: true,
+	})
+
+	if c.ConnectTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.ConnectTimeout))
+	}
+
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("gemini: handshake: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if err := c.verify(conn.ConnectionState(), addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// verify pins the certificate on first use and compares it with the pin afterwards.
+func (c *Client) verify(state tls.ConnectionState, addr string) error {
+	if c.KnownHosts == nil {
+		return nil
+	}
+
+	if len(state.PeerCertificates) == 0 {
+		return ErrMissingCertificate
+	}
+
+	cert := state.PeerCertificates[0]
+	fingerprint := Fingerprint(cert)
+
+	known, ok := c.KnownHosts.Lookup(addr)
+	if ok && known.Fingerprint == fingerprint {
+		return nil
+	} else if ok && time.Now().Before(known.Expires) {
+		return fmt.Errorf("%w: %s", ErrCertificateChanged, addr)
+	}
+
+	return c.KnownHosts.Add(addr, KnownHost{Fingerprint: fingerprint, Expires: cert.NotAfter})
+}
+
+// roundTrip sends the request and reads the response header. The returned reader is positioned
+// at the body.
+func (c *Client) roundTrip(conn *tls.Conn, req *Request) (*Response, *bufio.Reader, error) {
+	if c.ReadTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.ReadTimeout))
+	}
+
+	if _, err := conn.Write([]byte(req.URL.String() + Termination)); err != nil {
+		return nil, nil, fmt.Errorf("gemini: write request: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	status, meta, err := readResponseHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	state := conn.ConnectionState()
+	return &Response{Status: status, Meta: meta, Request: req, TLS: &state}, r, nil
+}
+
+// readResponseHeader reads and validates <STATUS><SPACE><META><CR><LF>.
+func readResponseHeader(r *bufio.Reader) (int, string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, "", fmt.Errorf("gemini: read response header: %w", err)
+		}
+
+		line = append(line, b)
+		if len(line) > responseHeaderMaxBytes {
+			return 0, "", ErrInvalidResponse
+		} else if b == '\n' {
+			break
+		}
+	}
+
+	header := string(line)
+	if !strings.HasSuffix(header, Termination) || len(header) < 2+len(Termination) {
+		return 0, "", ErrInvalidResponse
+	}
+	header = strings.TrimSuffix(header, Termination)
+
+	status, err := strconv.Atoi(header[:2])
+	if err != nil || status < 10 || status > 69 {
+		return 0, "", ErrInvalidResponse
+	}
+
+	meta := strings.TrimPrefix(header[2:], " ")
+	if len(header) > 2 && header[2] != ' ' {
+		return 0, "", ErrInvalidResponse
+	}
+	return status, meta, nil
+}
+
+// responseBody streams the body, each read is bound by the read timeout.
+type responseBody struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	if b.timeout > 0 {
+		b.conn.SetReadDeadline(time.Now().Add(b.timeout))
+	}
+	return b.r.Read(p)
+}
+
+func (b *responseBody) Close() error {
+	var err error
+	b.once.Do(func() {
+		close(b.done)
+		err = b.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,261 @@
+package gemini_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+func TestClientKnownHosts(t *testing.T) {
+	s := geminitest.NewServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		gemini.Success(w, "text/plain")
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	addr := s.Listener.Addr().String()
+	fingerprint := gemini.Fingerprint(s.Certificate)
+	known := gemini.NewKnownHosts()
+	client := &gemini.Client{KnownHosts: known, ConnectTimeout: time.Second, ReadTimeout: time.Second}
+
+	get := func() error {
+		rsp, err := client.Get(s.URL + "/")
+		if err == nil {
+			rsp.Body.Close()
+		}
+		return err
+	}
+
+	// first use pins the certificate
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	pin, ok := known.Lookup(addr)
+	if !ok || pin.Fingerprint != fingerprint || !pin.Expires.Equal(s.Certificate.NotAfter) {
+		t.Fatalf("expected pin of the server certificate, got %+v", pin)
+	}
+
+	// a different certificate is refused while the pin is valid
+	known.Add(addr, gemini.KnownHost{Fingerprint: "other", Expires: time.Now().Add(time.Hour)})
+	if err := get(); !errors.Is(err, gemini.ErrCertificateChanged) {
+		t.Fatalf("expected ErrCertificateChanged, got %v", err)
+	}
+
+	// and replaces the pin once it expired
+	known.Add(addr, gemini.KnownHost{Fingerprint: "other", Expires: time.Now().Add(-time.Hour)})
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	if pin, _ := known.Lookup(addr); pin.Fingerprint != fingerprint {
+		t.Errorf("expected pin to be replaced, got %+v", pin)
+	}
+}
+
+func TestKnownHostsCaseInsensitive(t *testing.T) {
+	known := gemini.NewKnownHosts()
+	known.Add("Example.ORG:1965", gemini.KnownHost{Fingerprint: "a"})
+
+	if pin, ok := known.Lookup("example.org:1965"); !ok || pin.Fingerprint != "a" {
+		t.Errorf("expected case insensitive lookup, got %+v %v", pin, ok)
+	}
+}
+
+func TestKnownHostsFile(t *testing.T) {
+	path := t.TempDir() + "/known_hosts"
+
+	// a missing file is created on the first Add
+	known, err := gemini.OpenKnownHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Unix(1735689600, 0)
+	pins := map[string]gemini.KnownHost{
+		"example.org:1965":    {Fingerprint: "0a1b", Expires: expires},
+		"gemini.example:1966": {Fingerprint: "2c3d", Expires: expires.Add(time.Hour)},
+	}
+	for host, pin := range pins {
+		if err := known.Add(host, pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	known.Add("EXAMPLE.org:1965", pins["example.org:1965"])
+
+	reopened, err := gemini.OpenKnownHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range pins {
+		got, ok := reopened.Lookup(host)
+		if !ok || got.Fingerprint != want.Fingerprint || !got.Expires.Equal(want.Expires) {
+			t.Errorf("%s: got %+v, want %+v", host, got, want)
+		}
+	}
+
+	// the mixed case Add replaced the pin instead of adding an entry
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != len(pins) {
+		t.Errorf("expected %d entries, got %d:\n%s", len(pins), lines, data)
+	}
+}
+
+func TestOpenKnownHostsInvalid(t *testing.T) {
+	for _, content := range []string{"example.org:1965 0a1b\n", "example.org:1965 0a1b never\n"} {
+		path := t.TempDir() + "/known_hosts"
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := gemini.OpenKnownHosts(path); !errors.Is(err, gemini.ErrInvalidKnownHost) {
+			t.Errorf("%q: expected ErrInvalidKnownHost, got %v", content, err)
+		}
+	}
+}
+
+func TestClientRedirects(t *testing.T) {
+	s := geminitest.NewServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			gemini.Redirect(w, "/target")
+		case "/loop":
+			gemini.Redirect(w, "/loop")
+		case "/web":
+			gemini.Redirect(w, "https://example.org/")
+		default:
+			gemini.Success(w, "text/plain")
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer s.Close()
+
+	client := s.Client()
+	rsp, err := client.Get(s.URL + "/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.Status != gemini.StatusSuccess || rsp.Request.URL.Path != "/target" {
+		t.Errorf("expected 20 for /target, got %d %s", rsp.Status, rsp.Request.URL)
+	}
+
+	if _, err := client.Get(s.URL + "/loop"); !errors.Is(err, gemini.ErrTooManyRedirects) {
+		t.Errorf("expected ErrTooManyRedirects, got %v", err)
+	}
+
+	if _, err := client.Get(s.URL + "/web"); !errors.Is(err, gemini.ErrUnknownProtocol) {
+		t.Errorf("expected ErrUnknownProtocol for a redirect to https, got %v", err)
+	}
+
+	// not following returns the redirect
+	client.MaxRedirects = -1
+	rsp, err = client.Get(s.URL + "/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.Status != gemini.StatusRedirectTemporary || rsp.Meta != "/target" {
+		t.Errorf("expected 30 /target, got %d %q", rsp.Status, rsp.Meta)
+	}
+}
+
+// rawServer answers a request for /<i> with headers[i] as is, bypassing the header validation of
+// the server.
+func rawServer(t *testing.T, headers []string) *geminitest.Server {
+	t.Helper()
+
+	s := geminitest.NewUnstartedServer(nil)
+	s.URL = "gemini://" + s.Listener.Addr().String()
+	l := tls.NewListener(s.Listener, s.Config.TLSConfig)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				u, err := url.Parse(strings.TrimSuffix(line, gemini.Termination))
+				if err != nil {
+					return
+				}
+				if i, err := strconv.Atoi(strings.TrimPrefix(u.Path, "/")); err == nil && i < len(headers) {
+					conn.Write([]byte(headers[i]))
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func TestClientResponseHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		status int
+		meta   string
+		valid  bool
+	}{
+		{header: "20 text/gemini\r\n", status: 20, meta: "text/gemini", valid: true},
+		{header: "51\r\n", status: 51, valid: true},
+		{header: "20 " + strings.Repeat("a", gemini.URLMaxBytes) + "\r\n", status: 20,
+			meta: strings.Repeat("a", gemini.URLMaxBytes), valid: true},
+		{header: "20 " + strings.Repeat("a", gemini.URLMaxBytes+1) + "\r\n"},
+		{header: "20 text/gemini\n"},
+		{header: "20text/gemini\r\n"},
+		{header: "2 text/gemini\r\n"},
+		{header: "ab text/gemini\r\n"},
+		{header: "09 text/gemini\r\n"},
+		{header: "70 text/gemini\r\n"},
+		{header: "\r\n"},
+	}
+
+	headers := make([]string, len(tests))
+	for i, tt := range tests {
+		headers[i] = tt.header
+	}
+	s := rawServer(t, headers)
+	defer s.Close()
+
+	client := s.Client()
+	for i, tt := range tests {
+		rsp, err := client.Get(s.URL + "/" + strconv.Itoa(i))
+		if !tt.valid {
+			if !errors.Is(err, gemini.ErrInvalidResponse) {
+				t.Errorf("%q: expected ErrInvalidResponse, got %v", tt.header, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", tt.header, err)
+			continue
+		}
+		rsp.Body.Close()
+		if rsp.Status != tt.status || rsp.Meta != tt.meta {
+			t.Errorf("%q: got %d %q", tt.header, rsp.Status, rsp.Meta)
+		}
+	}
+
+	// a connection closed before the header is complete is no valid response either
+	if _, err := client.Get(s.URL + "/" + strconv.Itoa(len(tests))); err == nil {
+		t.Error("expected error for a missing header")
+	}
+}
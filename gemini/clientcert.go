@@ -0,0 +1,32 @@
+package gemini
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+// Certificate returns the leaf certificate presented by the client or nil if there is none.
+func (r *Request) Certificate() *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the client leaf certificate as lowercase hex
+// string or an empty string if the client did not present a certificate.
+func (r *Request) Fingerprint() string {
+	cert := r.Certificate()
+	if cert == nil {
+		return ""
+	}
+	return Fingerprint(cert)
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the DER encoded certificate as lowercase hex
+// string.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,314 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnServer runs the connection lifecycle of servers that serve gemini handlers over other
+// protocols, like spartan and gopher: accepting with retries on temporary errors, limiting
+// concurrent connections, request contexts, panic recovery and draining on shutdown. The protocol
+// is left to the handle function passed to Serve.
+type ConnServer struct {
+	// Logger receives leveled server events, logging is disabled if nil.
+	Logger Logger
+
+	// ErrorHandler answers failures before the handler, see ServeError. Defaults to
+	// HandleRequestError.
+	ErrorHandler Handler
+
+	// MaxOpenConns limits the number of connections handled concurrently, zero means no limit.
+	// Beyond the limit no connections are accepted until one finished, clients wait in the listen
+	// backlog.
+	MaxOpenConns int
+
+	// HandlerTimeout cancels the request contexts, zero means no timeout.
+	HandlerTimeout time.Duration
+
+	// internal
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	listener    net.Listener
+	inShutdown  int32 // accessed atomically
+	activeConns map[net.Conn]ConnState
+	sem         chan struct{}
+}
+
+func (c *ConnServer) log(level Level, msg string, keyvals ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Log(level, msg, keyvals...)
+	}
+}
+
+// Serve accepts connections on l and calls handle for each in its own goroutine. The connection
+// is closed once handle returns. Serve always returns a non-nil error and closes l. After
+// Shutdown the returned error is ErrServerClosed.
+func (c *ConnServer) Serve(l net.Listener, handle func(net.Conn)) error {
+	c.mu.Lock()
+	if c.ShuttingDown() {
+		c.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	c.listener = l
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.activeConns = make(map[net.Conn]ConnState)
+	if c.MaxOpenConns > 0 {
+		c.sem = make(chan struct{}, c.MaxOpenConns)
+	}
+	c.mu.Unlock()
+	defer l.Close()
+
+	c.log(LevelInfo, "accepting new connections", "addr", l.Addr())
+	err := accept(l, c.ShuttingDown, c.log, func(conn net.Conn) {
+		if !c.acquire() {
+			conn.Close()
+			return
+		}
+		c.track(conn, true)
+
+		go func() {
+			defer func() {
+				conn.Close()
+				c.track(conn, false)
+				c.release()
+			}()
+
+			handle(conn)
+		}()
+	})
+
+	if c.ShuttingDown() {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// ShuttingDown reports whether Shutdown was called.
+func (c *ConnServer) ShuttingDown() bool {
+	return atomic.LoadInt32(&c.inShutdown) != 0
+}
+
+// acquire waits for a free connection slot, it reports false if the server shuts down first.
+func (c *ConnServer) acquire() bool {
+	if c.sem == nil {
+		return true
+	}
+
+	select {
+	case c.sem <- struct{}{}:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+func (c *ConnServer) release() {
+	if c.sem != nil {
+		<-c.sem
+	}
+}
+
+func (c *ConnServer) track(conn net.Conn, active bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if active {
+		c.activeConns[conn] = StateActive
+	} else {
+		delete(c.activeConns, conn)
+	}
+}
+
+// Context returns the server context, it is canceled on shutdown.
+func (c *ConnServer) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// RequestContext derives a request context from the server context and applies the handler
+// deadline if configured.
+func (c *ConnServer) RequestContext() (context.Context, context.CancelFunc) {
+	return requestContext(c.ctx, c.HandlerTimeout)
+}
+
+// ServeRequest calls the handler and recovers from panics like Server. The panic and stack are
+// logged and the client is answered with 40, unless a header was already written.
+func (c *ConnServer) ServeRequest(w ResponseWriter, r *Request, h Handler) {
+	serveHandler(h, w, r, c.log, func() {
+		// fails without writing if the handler wrote a header
+		ServeError(w, Error(StatusTemporaryFailure, ErrHandlerPanic))
+	})
+}
+
+// ServeError hands a failure before the handler to the ErrorHandler as a synthetic request with
+// Err set and its own request context, like Server.ErrorHandler. req may be nil if nothing was
+// read.
+func (c *ConnServer) ServeError(w ResponseWriter, conn net.Conn, req *Request, err error) {
+	ctx, cancel := c.RequestContext()
+	defer cancel()
+
+	serveErrorRequest(ctx, c.ErrorHandler, w, conn, req, err)
+}
+
+// Shutdown stops accepting new connections, cancels the request contexts and waits for in-flight
+// connections to finish. If the context deadline passes first, the remaining connections are
+// closed forcefully and an error reporting their number is returned.
+func (c *ConnServer) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&c.inShutdown, 1)
+
+	c.mu.Lock()
+	listener, cancel := c.listener, c.cancel
+	c.mu.Unlock()
+
+	if listener == nil {
+		// never started
+		return nil
+	}
+
+	cancel()
+	if err := listener.Close(); err != nil {
+		c.log(LevelWarn, "error while closing listener", "error", err)
+	}
+
+	if n, err := awaitConns(ctx, c.numConns, c.closeConns); err != nil {
+		return fmt.Errorf("shutdown closed %d connections: %w", n, err)
+	}
+	return nil
+}
+
+func (c *ConnServer) numConns() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.activeConns)
+}
+
+func (c *ConnServer) closeConns() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return closeConns(c.activeConns)
+}
+
+// closeConns forcefully closes and forgets all connections in conns and returns how many there
+// were. The caller holds the lock guarding conns.
+func closeConns(conns map[net.Conn]ConnState) int {
+	n := len(conns)
+	for conn := range conns {
+		conn.Close()
+		delete(conns, conn)
+	}
+	return n
+}
+
+// awaitConns polls numConns until all connections finished. If ctx is done first, the remaining
+// connections are closed with closeConns and their number is returned with the context error.
+func awaitConns(ctx context.Context, numConns, closeConns func() int) (int, error) {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for numConns() > 0 {
+		select {
+		case <-ctx.Done():
+			return closeConns(), ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return 0, nil
+}
+
+// serveHandler calls h and recovers from panics. The panic is logged with its stack, then
+// recovered answers the client.
+func serveHandler(h Handler, w ResponseWriter, r *Request, log func(Level, string, ...interface{}),
+	recovered func()) {
+	defer func() {
+		if v := recover(); v != nil {
+			log(LevelError, "handler panic recovered", "panic", v, "request", requestURI(r),
+				"remote", r.RemoteAddr, "stack", string(debug.Stack()))
+
+			recovered()
+		}
+	}()
+
+	h.ServeGemini(w, r)
+}
+
+// serveErrorRequest hands err to the error handler h as a synthetic request with context ctx, so
+// that middlewares see it like any other request. It carries the request line and URL of req,
+// which may be nil if nothing was read, and the peer and TLS state of conn. A nil h is
+// HandleRequestError.
+func serveErrorRequest(ctx context.Context, h Handler, w ResponseWriter, conn net.Conn, req *Request,
+	err error) {
+	r := &Request{URL: &url.URL{}}
+	if req != nil {
+		r.RequestURI = req.RequestURI
+		if req.URL != nil {
+			r.URL = req.URL
+		}
+	}
+
+	r.ctx = ctx
+	r.Err = err
+	r.RemoteAddr = conn.RemoteAddr().String()
+	if tlsConn, ok := conn.(*tls.Conn); ok && tlsConn.ConnectionState().HandshakeComplete {
+		state := tlsConn.ConnectionState()
+		r.TLS = &state
+	}
+
+	if h == nil {
+		h = HandlerFunc(HandleRequestError)
+	}
+	h.ServeGemini(w, r)
+}
+
+// accept calls handle for every connection accepted on l, temporary errors are retried. It
+// returns nil once shuttingDown reports true and the accept error otherwise.
+func accept(l net.Listener, shuttingDown func() bool, log func(Level, string, ...interface{}),
+	handle func(net.Conn)) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if shuttingDown() {
+				return nil
+			}
+
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				log(LevelWarn, "server accept error, retrying", "error", err)
+				time.Sleep(acceptRetryDelay)
+
+				continue
+			}
+
+			log(LevelError, "server accept error", "error", err)
+			return err
+		}
+
+		handle(conn)
+	}
+}
+
+// requestContext derives a request context from parent and applies the handler timeout if set.
+// A nil parent is the background context.
+func requestContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
@@ -0,0 +1,140 @@
+package gemini_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+func TestConnServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	c := &gemini.ConnServer{MaxOpenConns: 1}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- c.Serve(l, func(conn net.Conn) {
+			started <- struct{}{}
+			<-release
+			conn.Write([]byte("done"))
+		})
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		return conn
+	}
+
+	first := dial()
+	defer first.Close()
+	<-started
+
+	// the second connection waits for the slot of the first
+	second := dial()
+	defer second.Close()
+	select {
+	case <-started:
+		t.Fatal("second connection handled beyond MaxOpenConns")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	if body, _ := ioutil.ReadAll(first); string(body) != "done" {
+		t.Errorf("expected done, got %q", body)
+	}
+	<-started
+
+	// shutdown waits for the in-flight connection
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		shutdown <- c.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned before the connection finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	if body, _ := ioutil.ReadAll(second); string(body) != "done" {
+		t.Errorf("expected done, got %q", body)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	if err := <-served; !errors.Is(err, gemini.ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+func TestConnServerRecover(t *testing.T) {
+	c := &gemini.ConnServer{}
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+
+		w := gemini.NewConnWriter(server, 0)
+		r := &gemini.Request{RemoteAddr: "pipe"}
+		c.ServeRequest(&headerWriter{ConnWriter: w}, r, gemini.HandlerFunc(func(gemini.ResponseWriter, *gemini.Request) {
+			panic("boom")
+		}))
+		w.Flush()
+	}()
+
+	body, _ := ioutil.ReadAll(client)
+	if want := "40 " + gemini.ErrHandlerPanic.Error() + gemini.Termination; string(body) != want {
+		t.Errorf("expected %q, got %q", want, body)
+	}
+}
+
+func TestConnServerErrorContext(t *testing.T) {
+	var ctx context.Context
+	c := &gemini.ConnServer{
+		HandlerTimeout: time.Minute,
+		ErrorHandler: gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+			ctx = r.Context()
+		}),
+	}
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	c.ServeError(nil, server, nil, gemini.ErrEmptyRequest)
+
+	// the error request got its own context with the handler deadline, canceled afterwards
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("expected the handler deadline on the error request context")
+	}
+	if ctx.Err() == nil {
+		t.Error("expected the error request context to be canceled after the handler returned")
+	}
+}
+
+// headerWriter is a minimal ResponseWriter on top of ConnWriter.
+type headerWriter struct {
+	*gemini.ConnWriter
+}
+
+func (w *headerWriter) WriteHeader(code int, message string) (int, error) {
+	return w.Write([]byte(strconv.Itoa(code) + " " + message + gemini.Termination))
+}
@@ -0,0 +1,109 @@
+package gemini
+
+import (
+	"net"
+)
+
+// ConnState represents the state of a client connection, see Server.ConnState.
+type ConnState int
+
+const (
+	// StateNew is a freshly accepted connection.
+	StateNew ConnState = iota
+
+	// StateQueued is a connection waiting for a free slot.
+	StateQueued
+
+	// StateHandshake is a connection in the TLS handshake.
+	StateHandshake
+
+	// StateActive is a connection whose request is read and handled.
+	StateActive
+
+	// StateRejected is a connection answered by the overflow policy without being handled.
+	StateRejected
+
+	// StateClosed is a closed connection, this is a terminal state.
+	StateClosed
+)
+
+var stateName = map[ConnState]string{
+	StateNew:       "new",
+	StateQueued:    "queued",
+	StateHandshake: "handshake",
+	StateActive:    "active",
+	StateRejected:  "rejected",
+	StateClosed:    "closed",
+}
+
+func (c ConnState) String() string {
+	return stateName[c]
+}
+
+// Stats is a snapshot of the servers connection counters and gauges.
+type Stats struct {
+	// Counters since the server started.
+	Accepted          uint64
+	Rejected          uint64
+	TimedOut          uint64
+	Errored           uint64 // invalid requests and handler panics, not redirects or empty requests
+	HandshakeFailures uint64
+
+	// Current connections by state.
+	Queued      int
+	Handshaking int
+	Active      int
+}
+
+// Stats returns a snapshot of the connection statistics.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	for _, state := range s.activeConns {
+		switch state {
+		case StateQueued:
+			stats.Queued++
+		case StateHandshake:
+			stats.Handshaking++
+		case StateActive:
+			stats.Active++
+		}
+	}
+	return stats
+}
+
+// setState tracks the state of connections that are queued or in flight and calls the
+// ConnState hook.
+func (s *Server) setState(conn net.Conn, state ConnState) {
+	s.mu.Lock()
+	if s.activeConns == nil {
+		s.activeConns = make(map[net.Conn]ConnState)
+	}
+
+	switch state {
+	case StateNew:
+		s.stats.Accepted++
+	case StateRejected:
+		s.stats.Rejected++
+	}
+
+	if state == StateClosed {
+		delete(s.activeConns, conn)
+	} else {
+		s.activeConns[conn] = state
+	}
+	s.mu.Unlock()
+
+	if s.ConnState != nil {
+		s.ConnState(conn, state)
+	}
+}
+
+// count increments a counter, e.g. count(&s.stats.TimedOut).
+func (s *Server) count(counter *uint64) {
+	s.mu.Lock()
+	*counter++
+	s.mu.Unlock()
+}
@@ -3,23 +3,20 @@
 package gemini
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/url"
 	"os"
 	"os/signal"
-	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-	"unicode/utf8"
 )
 
 const (
@@ -51,15 +48,44 @@ const (
 )
 
 var (
-	ErrServerClosed    = errors.New("gemini: server closed")
-	ErrHeaderTooLong   = errors.New("gemini: header too long")
-	ErrMissingFile     = errors.New("gemini: no such file")
-	ErrEmptyRequest    = errors.New("gemini: empty request")
-	ErrEmptyRequestURL = errors.New("gemini: empty request URL")
-	ErrInvalidPath     = errors.New("gemini: path error")
-	ErrInvalidHost     = errors.New("gemini: empty host")
-	ErrInvalidUtf8     = errors.New("gemini: empty request URL")
-	ErrUnknownProtocol = fmt.Errorf("gemini: unknown protocol scheme")
+	ErrServerClosed          = errors.New("gemini: server closed")
+	ErrHeaderTooLong         = errors.New("gemini: header too long")
+	ErrMissingFile           = errors.New("gemini: no such file")
+	ErrEmptyRequest          = errors.New("gemini: empty request")
+	ErrEmptyRequestURL       = errors.New("gemini: empty request URL")
+	ErrInvalidPath           = errors.New("gemini: path error")
+	ErrInvalidURL            = errors.New("gemini: invalid request URL")
+	ErrInvalidHost           = errors.New("gemini: empty host")
+	ErrInvalidPort           = errors.New("gemini: invalid port")
+	ErrInvalidUtf8           = errors.New("gemini: invalid utf-8 in request URL")
+	ErrMissingTermination    = errors.New("gemini: request not terminated by CRLF")
+	ErrMissingScheme         = errors.New("gemini: request URL not absolute")
+	ErrUserInfo              = errors.New("gemini: userinfo not allowed")
+	ErrFragment              = errors.New("gemini: fragment not allowed")
+	ErrForeignHost           = errors.New("gemini: host not served")
+	ErrForeignPort           = errors.New("gemini: port not served")
+	ErrReadTimeout           = errors.New("gemini: read timeout")
+	ErrHandlerPanic          = errors.New("gemini: internal server error")
+	ErrTooManyRedirects      = errors.New("gemini: too many redirects")
+	ErrInvalidResponse       = errors.New("gemini: invalid response header")
+	ErrCertificateChanged    = errors.New("gemini: server certificate changed")
+	ErrInvalidKnownHost      = errors.New("gemini: invalid known hosts entry")
+	ErrUnknownProtocol       = fmt.Errorf("gemini: unknown protocol scheme")
+	ErrMissingTLSConfig      = errors.New("gemini: no TLSConfig or TLSConfigLoader set")
+	ErrMissingCertificate    = errors.New("gemini: no certificate configured")
+	ErrUnknownOverflowPolicy = errors.New("gemini: unknown overflow policy")
+	ErrInvalidStatus         = errors.New("gemini: invalid status code")
+	ErrMetaTooLong           = errors.New("gemini: meta exceeds 1024 bytes")
+	ErrInvalidMeta           = errors.New("gemini: meta contains line breaks")
+	ErrHeaderWritten         = errors.New("gemini: header already written")
+	ErrInvalidInput          = errors.New("gemini: invalid percent-encoding in input")
+	ErrInvalidTitanParams    = errors.New("gemini: invalid titan parameters")
+)
+
+const (
+	acceptRetryDelay     = 50 * time.Millisecond
+	shutdownPollInterval = 10 * time.Millisecond
+	rejectDrainTimeout   = 250 * time.Millisecond
 )
 
 type Request struct {
@@ -70,6 +96,43 @@ type Request struct {
 	// RequestURI is the unmodified request-target of the Request-Line  as sent by the client
 	// to a server. Usually the URL field should be used instead.
 	RequestURI string
+
+	// TLS holds the state of the TLS connection the request was received on, including the
+	// peer certificates if the client presented any.
+	TLS *tls.ConnectionState
+
+	// Titan holds the upload parameters of titan requests and is nil for gemini requests. Body
+	// streams the upload, limited to Titan.Size bytes. Each read is bound by the servers
+	// ReadTimeout.
+	Titan *Titan
+	Body  io.Reader
+
+	// Err is set on synthetic requests handed to the Server.ErrorHandler and describes the
+	// protocol level failure. A *GmiError carries the status the client is answered with. URL
+	// is never nil but may be empty if the request could not be parsed.
+	Err error
+}
+
+// Context returns the request's context. The context is cancelled when the client disconnects,
+// when the server shuts down or when the servers HandlerTimeout passed.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx. The provided ctx must
+// be non-nil.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
 }
 
 type ResponseWriter interface {
@@ -93,68 +156,188 @@ func (f HandlerFunc) ServeGemini(w ResponseWriter, r *Request) {
 }
 
 type Server struct {
-	// Addr is the address the server is listening on.
+	// Addr is the address the server is listening on. The "unix:/path" and "tcp:host:port"
+	// forms serve plain gemini without TLS, see ParseAddr.
 	Addr string
 
-	// Hostname or common name of the server. This is used for absolute redirects.
+	// Plaintext serves without TLS, for deployments that terminate TLS in a proxy in front of
+	// the server. Handlers see the requested host in the request URL, Request.TLS is nil.
+	Plaintext bool
+
+	// Hostname or common name of the server. If set, requests for other hosts are refused with
+	// 53. Leave it empty when serving several hosts, see HostMux.
 	Hostname string
 
-	// Logger enables logging of the gemini server for debugging purposes.
-	Logger *log.Logger
+	// Logger receives leveled server events such as timeouts, request errors and certificate
+	// reloads. Logging is disabled if nil. See StdLogger to adapt a standard library logger.
+	Logger Logger
+
+	// TLSConfig is the initial TLS config. If nil, it is loaded with TLSConfigLoader.
+	TLSConfig *tls.Config
 
-	TLSConfig       *tls.Config
+	// TLSConfigLoader loads the TLS config on start if TLSConfig is nil and again on SIGHUP.
+	// Certificates of a reloaded config are used for new handshakes, the listener keeps running.
+	// If reloading fails, the previous certificates stay in service.
 	TLSConfigLoader func() (*tls.Config, error)
 
-	Handler      Handler // handler to invoke
-	ReadTimeout  time.Duration
-	MaxOpenConns int
+	// ProxyProtocol enables reading PROXY protocol v1 and v2 headers, sent by a TCP proxy in
+	// front of the server, to learn the original client address. Headers are only accepted
+	// from connections originating in TrustedProxies, others are served as is.
+	ProxyProtocol  bool
+	TrustedProxies []*net.IPNet
+
+	// RequestClientCerts asks clients for a certificate during the handshake. Certificates are
+	// not verified against a CA, self-signed certificates are accepted as the protocol allows.
+	// Authorization is left to handlers and middlewares, see Request.Certificate.
+	RequestClientCerts bool
+
+	// Proxy forwards requests for its allowlisted foreign hosts upstream instead of refusing them
+	// with 53. Proxied requests bypass Handler and the host and port checks. Disabled if nil.
+	Proxy *Proxy
+
+	// ProxyHandler serves the requests allowed by Proxy, e.g. Proxy behind the middlewares of an
+	// access log. Defaults to Proxy.
+	ProxyHandler Handler
+
+	// Titan accepts titan:// upload requests, the handler reads the upload from Request.Body.
+	// Without it they are refused with 53 like other foreign schemes.
+	Titan bool
+
+	Handler Handler // handler to invoke
+
+	// ErrorHandler answers requests that fail before reaching Handler: bad requests, redirects,
+	// foreign hosts, read timeouts and rejected connections. It receives a synthetic request with
+	// Err set and may be wrapped with the same middlewares as Handler, e.g. for access logs.
+	// Defaults to HandleRequestError.
+	ErrorHandler Handler
+
+	// ReadTimeout bounds reading the request line, HandshakeTimeout the TLS handshake before it.
+	// HandshakeTimeout defaults to ReadTimeout. Clients too slow to send a request are answered
+	// with 41. Zero means no timeout.
+	ReadTimeout      time.Duration
+	HandshakeTimeout time.Duration
+
+	// MaxOpenConns limits the number of connections handled concurrently, zero means no limit.
+	// OverflowPolicy decides what happens to connections beyond the limit. QueueSize defaults to
+	// MaxOpenConns, QueueTimeout to ReadTimeout. Queued connections wait for a slot without
+	// limit if both are zero.
+	MaxOpenConns   int
+	OverflowPolicy OverflowPolicy
+	QueueSize      int
+	QueueTimeout   time.Duration
+
+	// MaxConnsPerIP limits concurrent connections per client address, so a single client can't
+	// hold every slot. Clients beyond the limit are answered with 44 on accept, behind a PROXY
+	// protocol listener once the request was read. The limit is not applied to unix socket peers
+	// and to plaintext connections without PROXY protocol, the peer is the TLS terminator and all
+	// clients would share its address. Zero means no limit.
+	MaxConnsPerIP int
+
+	// ConnState is called when a client connection changes state, see ConnState for the states.
+	ConnState func(net.Conn, ConnState)
+
+	// SlowDown is the wait time announced with 44 SLOW DOWN responses, defaults to one second.
+	SlowDown time.Duration
+
+	// WriteTimeout is the maximum duration a single write to the client may take before the
+	// connection is considered dead. It is reset on every write, streaming responses are not
+	// bound by it as a whole. Zero means no timeout.
+	WriteTimeout time.Duration
+
+	// HandlerTimeout is the maximum duration a handler may take before the request context is
+	// cancelled. Zero means no deadline.
+	HandlerTimeout time.Duration
 
 	// internal
+	ctx            context.Context
+	cancel         context.CancelFunc
+	mu             sync.Mutex
 	listener       net.Listener
-	shutdown       bool
+	tlsConfig      atomic.Value // *tls.Config
+	inShutdown     int32        // accessed atomically
+	activeConns    map[net.Conn]ConnState
+	stats          Stats
+	connsPerIP     map[string]int
+	connIPs        map[net.Conn]string
+	sem            chan struct{}
+	queued         int32 // accessed atomically
 	closed         chan struct{}
 	sighupListener chan struct{}
 }
 
-func (s *Server) log(v string) {
+func (s *Server) log(level Level, msg string, keyvals ...interface{}) {
 	if s.Logger == nil {
 		return
 	}
 
-	s.Logger.Println("gmifs: " + v)
+	s.Logger.Log(level, msg, keyvals...)
 }
 
-func (s *Server) logf(format string, v ...interface{}) {
-	if s.Logger == nil {
-		return
+// loadTLS loads the TLS config and puts it in service for new handshakes. On error the previous
+// config stays in service.
+func (s *Server) loadTLS() error {
+	if s.TLSConfigLoader == nil {
+		return ErrMissingTLSConfig
 	}
 
-	s.log(fmt.Sprintf(format, v...))
+	config, err := s.TLSConfigLoader()
+	if err != nil {
+		return err
+	}
+
+	s.tlsConfig.Store(config)
+	return nil
+}
+
+// initTLS puts TLSConfig in service if set and falls back to the loader otherwise.
+func (s *Server) initTLS() error {
+	if s.TLSConfig != nil {
+		s.tlsConfig.Store(s.TLSConfig)
+		return nil
+	}
+	return s.loadTLS()
 }
 
-func (s *Server) loadTLS() (err error) {
-	s.TLSConfig, err = s.TLSConfigLoader()
-	return err
+// listenerTLSConfig derives the config for the listener from the loaded config. Certificates are
+// resolved on every handshake through GetCertificate, which allows swapping them without
+// restarting the listener.
+func (s *Server) listenerTLSConfig() *tls.Config {
+	config := s.tlsConfig.Load().(*tls.Config).Clone()
+	config.Certificates = nil
+	config.GetCertificate = s.getCertificate
+
+	if s.RequestClientCerts {
+		config.ClientAuth = tls.RequestClientCert
+	}
+
+	return config
+}
+
+func (s *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return certificateFor(s.tlsConfig.Load().(*tls.Config), hello)
 }
 
-func (s *Server) reloadTLSConfigOnSighup() {
+// reloadTLSConfigOnSighup reloads the certificate on SIGHUP until the accept loop stopped. If
+// reload is false the signal is ignored.
+func (s *Server) reloadTLSConfigOnSighup(reload bool) {
 	hup := make(chan os.Signal, 1)
 	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
 
 	for {
 		select {
 		case <-hup:
+			if !reload {
+				s.log(LevelDebug, "sighup ignored, no certificate to reload")
+				continue
+			}
 
-			s.log("reloading certificate")
-			if s.listener != nil {
-				err := s.loadTLS()
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "critical: failed to load tls certs: %v", err)
-					os.Exit(1)
-				}
-
-				s.listener.Close()
+			s.log(LevelInfo, "reloading certificate")
+			if err := s.loadTLS(); err != nil {
+				s.log(LevelError, "reloading certificate failed, keeping previous", "error", err)
+				continue
 			}
+			s.log(LevelInfo, "certificate reloaded")
 		case <-s.closed:
 			close(s.sighupListener)
 			return
@@ -162,249 +345,358 @@ func (s *Server) reloadTLSConfigOnSighup() {
 	}
 }
 
+// ParseAddr splits a listen address into network and address. Addresses prefixed with "unix:"
+// or "tcp:" are served without TLS, plain addresses such as ":1965" with TLS over TCP.
+func ParseAddr(addr string) (network, address string, plaintext bool) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return "unix", strings.TrimPrefix(addr, "unix:"), true
+	case strings.HasPrefix(addr, "tcp:"):
+		return "tcp", strings.TrimPrefix(addr, "tcp:"), true
+	default:
+		return "tcp", addr, false
+	}
+}
+
+// ListenAndServe listens on s.Addr and then serves it. See ParseAddr for the address format.
 func (s *Server) ListenAndServe() error {
-	err := s.loadTLS()
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	network, address, plaintext := ParseAddr(s.Addr)
+	l, err := net.Listen(network, address)
 	if err != nil {
-		return err
+		return fmt.Errorf("gemini server listen: %w", err)
 	}
 
-	s.sighupListener = make(chan struct{})
-	go s.reloadTLSConfigOnSighup()
+	return s.serve(l, s.Plaintext || plaintext)
+}
 
-	// outer for loop, if listener closes we will restart it. This may be useful if we switch out
-	// TLSConfig.
-	for {
-		s.closed = make(chan struct{})
+// Serve accepts connections on l, wraps them with the TLS config unless Plaintext is set and
+// serves them with the handler. The listener may be inherited, e.g. through systemd socket
+// activation. Serve always returns a non-nil error and closes l. After Shutdown the returned
+// error is ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(l, s.Plaintext)
+}
 
-		var err error
+func (s *Server) serve(l net.Listener, plaintext bool) error {
+	if s.shuttingDown() {
+		l.Close()
+		return ErrServerClosed
+	}
 
-		s.listener, err = tls.Listen("tcp", s.Addr, s.TLSConfig)
-		if err != nil {
-			return fmt.Errorf("gemini server listen: %w", err)
+	if !plaintext {
+		if err := s.initTLS(); err != nil {
+			l.Close()
+			return err
 		}
+	}
 
-		queue := make(chan net.Conn, s.MaxOpenConns)
-		go s.handleConnectionQueue(queue)
+	if s.ProxyProtocol {
+		if _, unix := l.Addr().(*net.UnixAddr); len(s.TrustedProxies) == 0 && !unix {
+			l.Close()
+			return ErrNoTrustedProxies
+		}
+		l = &proxyListener{Listener: l, trusted: s.TrustedProxies}
+	}
 
-		s.logf("Accepting new connections on %v", s.listener.Addr())
-		for {
-			conn, err := s.listener.Accept()
-			if err != nil {
-				s.logf("server accept error: %v", err)
+	listener := l
+	if !plaintext {
+		listener = tls.NewListener(l, s.listenerTLSConfig())
+	}
 
-				break
-			}
-			queue <- conn
+	s.mu.Lock()
+	if s.shuttingDown() {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.closed = make(chan struct{})
+	s.sighupListener = make(chan struct{})
+	s.mu.Unlock()
+	defer s.cancel()
 
-			// un-stuck call after shutdown will trigger a drop here
-			if s.shutdown {
-				break
-			}
-		}
+	// without TLS there is nothing to reload, the signal is still handled so that renewal and
+	// log rotation hooks don't terminate the process.
+	go s.reloadTLSConfigOnSighup(!plaintext)
 
-		// closed confirms the accept call stopped
-		close(s.closed)
-		if s.shutdown {
-			break
-		}
+	// semaphore for connection limiter, unlimited if not set
+	if s.MaxOpenConns > 0 {
+		s.sem = make(chan struct{}, s.MaxOpenConns)
 	}
 
-	s.log("closing listener gracefully")
-	return s.listener.Close()
-}
+	if s.MaxConnsPerIP > 0 && plaintext && !s.ProxyProtocol {
+		s.log(LevelWarn, "connection limit per ip disabled, client addresses are unknown without tls or proxy protocol")
+	}
 
-func (s *Server) handleConnectionQueue(queue chan net.Conn) {
-	// semaphore for connection limiter
-	type semaphore chan struct{}
-	sem := make(semaphore, s.MaxOpenConns)
-	for {
-		// for each connection we receive
-		conn := <-queue
-		sem <- struct{}{} // acquire
-		go s.handleConnection(conn, sem)
+	s.log(LevelInfo, "accepting new connections", "addr", listener.Addr())
+	acceptErr := accept(listener, s.shuttingDown, s.log, func(conn net.Conn) {
+		s.setState(conn, StateNew)
+		s.admit(conn)
+	})
+
+	// closed confirms the accept call stopped
+	close(s.closed)
+
+	if s.shuttingDown() {
+		return ErrServerClosed
 	}
+
+	listener.Close()
+	return fmt.Errorf("gemini server accept: %w", acceptErr)
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
 }
 
-func (s *Server) handleConnection(conn net.Conn, sem chan struct{}) {
+func (s *Server) numConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.activeConns)
+}
+
+func (s *Server) closeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return closeConns(s.activeConns)
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
 	defer func() {
 		conn.Close()
-		<-sem // release
+		s.setState(conn, StateClosed)
+		s.releaseIP(conn)
+		s.release()
 	}()
 
-	reqChan := make(chan request)
-	w := &writer{conn}
-
-	// push job for which we allocated a sem slot and wait
-	go requestChannel(conn, reqChan)
-	select {
-	case header := <-reqChan:
-		if header.err != nil {
-			s.handleRequestError(conn, w, header)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		s.setState(conn, StateHandshake)
+		if err := s.handshake(tlsConn); err != nil {
+			s.count(&s.stats.HandshakeFailures)
+			s.log(LevelDebug, "tls handshake error", "error", err, "remote", conn.RemoteAddr())
 
 			return
 		}
-
-		ctx := context.Background()
-		r := &Request{
-			ctx:        ctx,
-			URL:        header.URL,
-			RequestURI: header.rawuri,
-			RemoteAddr: conn.RemoteAddr().String(),
+	}
+	s.setState(conn, StateActive)
+
+	w := newWriter(conn, s.WriteTimeout)
+	defer w.Flush()
+
+	// the request line is read inline, bound by the read deadline and the URL size limit.
+	handler := s.Handler
+	req, err := s.readRequest(conn)
+	if err == nil && s.proxied(req.URL) {
+		s.log(LevelDebug, "proxy request", "url", req.URL, "remote", conn.RemoteAddr())
+		handler = s.ProxyHandler
+		if handler == nil {
+			handler = s.Proxy
 		}
-
-		s.Handler.ServeGemini(w, r)
-
-	case <-time.After(s.ReadTimeout):
-		s.logf("server read timeout, request queue length %v/%v", len(sem), s.MaxOpenConns)
-		w.WriteHeader(StatusServerUnavailable, "")
+	} else if err == nil {
+		err = s.checkHost(req.URL, conn.LocalAddr())
 	}
-}
 
-func (s *Server) handleRequestError(conn net.Conn, w ResponseWriter, req request) {
-	if errors.Is(req.err, ErrEmptyRequest) {
-		// in debug mode we log these too
-		s.logf("empty request ignored - %v", conn.RemoteAddr().String())
+	if err != nil {
+		s.handleRequestError(conn, w, req, err)
+
 		return
 	}
 
-	var gmierr *GmiError
-	if errors.As(req.err, &gmierr) {
-		// notify if error or redirect
-		if gmierr.Code == StatusRedirectPermanent || gmierr.Code == StatusRedirectTemporary {
-			s.logf("redirect '%s' -> '%s' %d - %s",
-				strings.TrimSpace(req.URL.Path), req.err, gmierr.Code, conn.RemoteAddr().String())
-		} else {
-			s.logf("read request error: '%s' %v %d - %s",
-				strings.TrimSpace(req.rawuri), req.err, gmierr.Code, conn.RemoteAddr().String())
+	// behind a proxy the client address is final once the request was read, otherwise the limit
+	// was applied on accept.
+	if s.ProxyProtocol && s.limitsIP(conn) {
+		if !s.acquireIP(conn) {
+			s.count(&s.stats.Rejected)
+			s.log(LevelWarn, "connection limit per ip reached", "remote", conn.RemoteAddr())
+			s.serveError(conn, w, req, Error(StatusSlowDown, errors.New(s.slowDownMeta())))
+
+			return
 		}
+	}
 
-		w.WriteHeader(gmierr.Code, gmierr.Error())
+	ctx, cancel := s.requestContext()
+	defer cancel()
 
-		return
+	if req.Titan != nil {
+		req.Body = &bodyReader{conn: conn, r: req.Body, timeout: s.ReadTimeout}
+	} else {
+		// the client sends nothing after the request line, a failing read means it is gone.
+		go watchDisconnect(conn, cancel)
 	}
+	w.onWriteError = cancel
 
-	// this path doesn't exist currently.
-	s.logf("unexpected error: '%s' %v - %s",
-		strings.TrimSpace(req.rawuri), req.err, conn.RemoteAddr().String())
-	w.WriteHeader(StatusTemporaryFailure, "internal")
+	req.ctx = ctx
+	req.RemoteAddr = conn.RemoteAddr().String()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		req.TLS = &state
+	}
+
+	s.serveRequest(conn, w, req, handler)
 }
 
-// conn handler
+// serveRequest calls the handler and recovers from panics. The panic and stack are logged and the
+// client is answered with 40 through the error handler, unless a header was already written.
+func (s *Server) serveRequest(conn net.Conn, w *writer, req *Request, handler Handler) {
+	serveHandler(handler, w, req, s.log, func() {
+		s.count(&s.stats.Errored)
+		if !w.wroteHeader {
+			s.serveError(conn, w, req, Error(StatusTemporaryFailure, ErrHandlerPanic))
+		}
+	})
+}
 
-type request struct {
-	rawuri string
-	URL    *url.URL
-	err    error
+// readRequest reads the request line within the read timeout. The deadline is cleared
+// afterwards.
+func (s *Server) readRequest(conn net.Conn) (*Request, error) {
+	if s.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	return ParseRequest(conn)
 }
 
-func requestChannel(c net.Conn, rsp chan request) {
-	req := &request{}
+// handshake completes the TLS handshake within the handshake timeout.
+func (s *Server) handshake(conn *tls.Conn) error {
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = s.ReadTimeout
+	}
 
-	r, err := readHeader(c)
-	if r != nil {
-		req = r
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
 	}
+	return conn.Handshake()
+}
 
-	req.err = err
-	rsp <- *req
+// requestContext derives a request context from the server context and applies the handler
+// deadline if configured.
+func (s *Server) requestContext() (context.Context, context.CancelFunc) {
+	return requestContext(s.ctx, s.HandlerTimeout)
 }
 
-func readHeader(c net.Conn) (*request, error) {
-	req, err := bufio.NewReader(c).ReadString('\r')
-	if err != nil {
-		// not sure this is the right response
-		return nil, Error(StatusTemporaryFailure, ErrEmptyRequest)
+// watchDisconnect cancels the request context once the connection failed, e.g. was reset by the
+// client. A client that half-closed after the request line still waits for the response, so a
+// bare EOF ends the watch without canceling. Stray bytes after the request line are discarded.
+// The read unblocks when the connection is closed after the handler returned.
+func watchDisconnect(conn net.Conn, cancel context.CancelFunc) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err == io.EOF {
+			return
+		} else if err != nil {
+			cancel()
+			return
+		}
 	}
+}
 
-	r := &request{}
-	r.rawuri = req
+func (s *Server) handleRequestError(conn net.Conn, w ResponseWriter, req *Request, err error) {
+	if errors.Is(err, ErrReadTimeout) {
+		s.count(&s.stats.TimedOut)
+		s.log(LevelWarn, "server read timeout", "remote", conn.RemoteAddr(),
+			"inflight", len(s.sem), "max", s.MaxOpenConns)
+		s.serveError(conn, w, req, err)
 
-	requestURL := strings.TrimSpace(req)
-	if requestURL == "" {
-		return r, Error(StatusBadRequest, ErrEmptyRequestURL)
-	} else if len(requestURL) > URLMaxBytes {
-		return r, Error(StatusBadRequest, ErrHeaderTooLong)
-	} else if !utf8.ValidString(requestURL) {
-		return r, Error(StatusBadRequest, ErrInvalidUtf8)
+		return
 	}
 
-	parsedURL, err := url.Parse(requestURL)
-	if err != nil {
-		return r, Error(StatusBadRequest, err)
+	if errors.Is(err, ErrEmptyRequest) {
+		// in debug mode we log these too, there is nobody to answer.
+		s.log(LevelDebug, "empty request ignored", "remote", conn.RemoteAddr())
+		return
 	}
 
-	r.URL = parsedURL
-	return validateRequest(r)
-}
+	var gmierr *GmiError
+	if errors.As(err, &gmierr) {
+		// notify if error or redirect
+		if gmierr.Code == StatusRedirectPermanent || gmierr.Code == StatusRedirectTemporary {
+			s.log(LevelDebug, "redirect", "request", requestURI(req), "target", err,
+				"status", gmierr.Code, "remote", conn.RemoteAddr())
+		} else {
+			s.count(&s.stats.Errored)
+			s.log(LevelWarn, "read request error", "request", requestURI(req),
+				"error", err, "status", gmierr.Code, "remote", conn.RemoteAddr())
+		}
 
-func validateRequest(r *request) (*request, error) {
-	if r.URL.Scheme != "" && r.URL.Scheme != "gemini" {
-		return r, Error(StatusProxyRequestRefused, ErrUnknownProtocol)
-	} else if r.URL.Host == "" {
-		return r, Error(StatusBadRequest, ErrInvalidHost)
-	}
+		s.serveError(conn, w, req, err)
 
-	if r.URL.Path == "" {
-		// This error is a redirect path.
-		return r, Error(StatusRedirectPermanent, errors.New("./"+r.URL.Path))
-	} else if cleaned := path.Clean(r.URL.Path); cleaned != r.URL.Path {
-		// check valid alternative if unclean for directories
-		if cleaned != strings.TrimRight(r.URL.Path, "/") {
-			return r, Error(StatusBadRequest, ErrInvalidPath)
-		}
+		return
 	}
 
-	return r, nil
+	// this path doesn't exist currently.
+	s.count(&s.stats.Errored)
+	s.log(LevelError, "unexpected error", "request", requestURI(req),
+		"error", err, "remote", conn.RemoteAddr())
+	s.serveError(conn, w, req, err)
+}
+
+// serveError hands a protocol level failure to the ErrorHandler as a synthetic request, so that
+// middlewares see it like any other request. req may be nil if nothing was read.
+func (s *Server) serveError(conn net.Conn, w ResponseWriter, req *Request, err error) {
+	ctx, cancel := s.requestContext()
+	defer cancel()
+
+	serveErrorRequest(ctx, s.ErrorHandler, w, conn, req, err)
+}
+
+// HandleRequestError is the default Server.ErrorHandler. It answers r.Err with ServeError.
+func HandleRequestError(w ResponseWriter, r *Request) {
+	ServeError(w, r.Err)
+}
+
+// requestURI returns the raw request line for logging with titan tokens redacted, r may be nil if
+// nothing was read.
+func requestURI(r *Request) string {
+	if r == nil {
+		return ""
+	}
+	return redactToken(r.RequestURI)
 }
 
-// Shutdown uses the self-pipe trick to gracefully allow the accept handler to exit and the listener
-// to close within the given context deadline. If unsuccessful the listener is forcefully
-// terminated.
+// Shutdown stops accepting new connections immediately, cancels the request contexts and waits
+// for queued and in-flight connections to finish. If the context deadline passes first, the
+// remaining connections are closed forcefully and an error reporting their number is returned.
 func (s *Server) Shutdown(ctx context.Context) error {
-	s.log("shutdown request received")
+	s.log(LevelInfo, "shutdown request received")
 	t := time.Now()
-	go func() {
-		s.shutdown = true
-		// un-stuck call to self
-		conn, err := tls.Dial("tcp", s.Addr, &tls.Config{InsecureSkipVerify: true})
-		if err != nil {
-			s.logf("un-stuck call failed (ok): %v", err)
+	atomic.StoreInt32(&s.inShutdown, 1)
 
-			return
-		}
-		defer conn.Close()
-	}()
+	s.mu.Lock()
+	listener, cancel, closed, sighupListener := s.listener, s.cancel, s.closed, s.sighupListener
+	s.mu.Unlock()
 
-	select {
-	case <-s.closed:
-		s.log("all clients exited")
-	case <-ctx.Done():
-		s.logf("shutdown: context deadline exceeded after %v, terminating listener", time.Since(t))
-		if err := s.listener.Close(); err != nil {
-			s.logf("error while closing listener %v", err)
-		}
+	if listener == nil {
+		// never started
+		return nil
 	}
-	// confirm sighup listener for cert reloading exited
-	<-s.sighupListener
 
-	return nil
-}
+	// notify in-flight handlers and stop accepting
+	cancel()
+	if err := listener.Close(); err != nil {
+		s.log(LevelWarn, "error while closing listener", "error", err)
+	}
 
-type writer struct {
-	w io.Writer
-}
+	if n, err := awaitConns(ctx, s.numConns, s.closeConns); err != nil {
+		s.log(LevelWarn, "shutdown deadline exceeded, closed connections", "elapsed", time.Since(t),
+			"closed", n)
 
-func (w *writer) WriteHeader(code int, message string) (int, error) {
-	// <STATUS><SPACE><META><CR><LF>
-	if len(message) == 0 {
-		return w.Write([]byte(fmt.Sprintf("%d%s", code, Termination)))
+		return fmt.Errorf("gemini: shutdown closed %d connections: %w", n, err)
 	}
 
-	return w.Write([]byte(fmt.Sprintf("%d %s%s", code, message, Termination)))
-}
+	// confirm accept loop and sighup listener for cert reloading exited
+	<-closed
+	<-sighupListener
+	s.log(LevelInfo, "all clients exited", "elapsed", time.Since(t))
 
-func (w *writer) Write(body []byte) (int, error) {
-	reader := bytes.NewReader(body)
-	n, err := io.Copy(w.w, reader)
-	return int(n), err
+	return nil
 }
@@ -0,0 +1,169 @@
+package gemini_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+// recordingLogger keeps the logged events for inspection.
+type recordingLogger struct {
+	mu     sync.Mutex
+	events []event
+}
+
+type event struct {
+	level  gemini.Level
+	msg    string
+	fields map[string]interface{}
+}
+
+func (l *recordingLogger) Log(level gemini.Level, msg string, keyvals ...interface{}) {
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields[keyvals[i].(string)] = keyvals[i+1]
+	}
+
+	l.mu.Lock()
+	l.events = append(l.events, event{level: level, msg: msg, fields: fields})
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) find(msg string) (event, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range l.events {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return event{}, false
+}
+
+func TestServerPanicLogged(t *testing.T) {
+	logger := &recordingLogger{}
+	s := geminitest.NewUnstartedServer(gemini.HandlerFunc(func(gemini.ResponseWriter, *gemini.Request) {
+		panic("boom")
+	}))
+	s.Config.Logger = logger
+	s.Start()
+
+	rsp, err := s.Client().Get(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	s.Close()
+
+	if rsp.Status != gemini.StatusTemporaryFailure {
+		t.Errorf("expected 40, got %d", rsp.Status)
+	}
+
+	e, ok := logger.find("handler panic recovered")
+	if !ok {
+		t.Fatal("panic not logged")
+	}
+	if e.level != gemini.LevelError || e.fields["panic"] != "boom" {
+		t.Errorf("unexpected event %v %v", e.level, e.fields["panic"])
+	}
+	if stack, _ := e.fields["stack"].(string); !strings.Contains(stack, "TestServerPanicLogged") {
+		t.Errorf("expected stack of the handler, got %q", stack)
+	}
+}
+
+func TestRequestContextDisconnect(t *testing.T) {
+	canceled := make(chan bool, 1)
+	s := geminitest.NewServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled <- true
+		case <-time.After(200 * time.Millisecond):
+			canceled <- false
+			gemini.Success(w, "text/plain")
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer s.Close()
+
+	addr := s.Listener.Addr().String()
+	dial := func() (*net.TCPConn, *tls.Conn) {
+		raw, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := tls.Client(raw, &tls.Config{InsecureSkipVerify: true})
+		if _, err := conn.Write([]byte(s.URL + "/" + gemini.Termination)); err != nil {
+			t.Fatal(err)
+		}
+		return raw.(*net.TCPConn), conn
+	}
+
+	// a client that half-closed after the request still waits for the response
+	raw, conn := dial()
+	conn.CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	body, err := ioutil.ReadAll(conn)
+	raw.Close()
+	if err != nil || string(body) != "20 text/plain"+gemini.Termination+"ok" {
+		t.Errorf("half-close: got %q, %v", body, err)
+	}
+	if <-canceled {
+		t.Error("half-close canceled the request context")
+	}
+
+	// a reset connection cancels it
+	raw, _ = dial()
+	time.Sleep(50 * time.Millisecond)
+	raw.SetLinger(0)
+	raw.Close()
+	if !<-canceled {
+		t.Error("reset did not cancel the request context")
+	}
+}
+
+func TestStatsErrored(t *testing.T) {
+	s := geminitest.NewServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		gemini.Success(w, "text/plain")
+	}))
+
+	tests := []struct {
+		request string // without termination, empty sends nothing
+		header  string
+	}{
+		{request: s.URL, header: "31 " + s.URL + "/"},
+		{request: "http://example.org/", header: "53 "},
+		{header: ""},
+	}
+
+	for _, tt := range tests {
+		conn, err := tls.Dial("tcp", s.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.request != "" {
+			conn.Write([]byte(tt.request + gemini.Termination))
+		}
+		conn.CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		rsp, _ := ioutil.ReadAll(conn)
+		conn.Close()
+
+		if !strings.HasPrefix(string(rsp), tt.header) {
+			t.Errorf("%q: expected %q, got %q", tt.request, tt.header, rsp)
+		}
+	}
+	s.Close()
+
+	// only the invalid request failed, the redirect and the empty request did not
+	if n := s.Config.Stats().Errored; n != 1 {
+		t.Errorf("expected 1 errored request, got %d", n)
+	}
+}
@@ -0,0 +1,36 @@
+package gemini
+
+import (
+	"strings"
+)
+
+// HostMux dispatches requests to a handler by the hostname in the request URL. Requests for
+// unknown hosts, or for a host other than the one negotiated via SNI, are refused with 53.
+type HostMux struct {
+	hosts map[string]Handler
+}
+
+func NewHostMux() *HostMux {
+	return &HostMux{hosts: make(map[string]Handler)}
+}
+
+// Handle registers the handler for the given hostname. Hostnames are case insensitive.
+func (m *HostMux) Handle(host string, handler Handler) {
+	m.hosts[strings.ToLower(host)] = handler
+}
+
+func (m *HostMux) ServeGemini(w ResponseWriter, r *Request) {
+	host := strings.ToLower(r.URL.Hostname())
+	if r.TLS != nil && r.TLS.ServerName != "" && !strings.EqualFold(r.TLS.ServerName, host) {
+		w.WriteHeader(StatusProxyRequestRefused, "host does not match sni")
+		return
+	}
+
+	handler, ok := m.hosts[host]
+	if !ok {
+		w.WriteHeader(StatusProxyRequestRefused, "unknown host")
+		return
+	}
+
+	handler.ServeGemini(w, r)
+}
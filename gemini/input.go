@@ -0,0 +1,97 @@
+package gemini
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Input returns the users answer to a 10 or 11 prompt, the percent-decoded query of the request
+// URL. Unlike form values a '+' is not a space. The input is empty if there is no query.
+func (r *Request) Input() (string, error) {
+	if r.URL == nil || r.URL.RawQuery == "" {
+		return "", nil
+	}
+
+	input, err := url.PathUnescape(r.URL.RawQuery)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	return input, nil
+}
+
+// HasInput reports whether the request carries a non-empty query.
+func (r *Request) HasInput() bool {
+	return r.URL != nil && r.URL.RawQuery != ""
+}
+
+// InputHandler returns a handler that answers requests without a query with 10 and prompt and
+// passes requests with input to next. Malformed input is answered with 59.
+func InputHandler(prompt string, next Handler) Handler {
+	return inputHandler(StatusInput, prompt, next)
+}
+
+// SensitiveInputHandler is like InputHandler but answers with 11, the client should not echo
+// the input, e.g. for passwords.
+func SensitiveInputHandler(prompt string, next Handler) Handler {
+	return inputHandler(StatusSensitiveInput, prompt, next)
+}
+
+func inputHandler(code int, prompt string, next Handler) Handler {
+	fn := func(w ResponseWriter, r *Request) {
+		if !r.HasInput() {
+			w.WriteHeader(code, prompt)
+			return
+		}
+
+		if _, err := r.Input(); err != nil {
+			ServeError(w, Error(StatusBadRequest, ErrInvalidInput))
+			return
+		}
+
+		next.ServeGemini(w, r)
+	}
+	return HandlerFunc(fn)
+}
+
+// prompt is a per path input prompt registered in the Mux.
+type prompt struct {
+	code int
+	text string
+}
+
+// prompts holds the per path prompts of a Mux.
+type prompts struct {
+	sync.RWMutex
+	paths map[string]prompt
+}
+
+func (p *prompts) set(path string, code int, text string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.paths == nil {
+		p.paths = make(map[string]prompt)
+	}
+	p.paths[path] = prompt{code: code, text: text}
+}
+
+func (p *prompts) get(path string) (prompt, bool) {
+	p.RLock()
+	defer p.RUnlock()
+
+	pr, ok := p.paths[path]
+	return pr, ok
+}
+
+// handler wraps the endpoint, requests to a path with a prompt must carry input to reach it.
+func (p *prompts) handler(endpoint Handler) Handler {
+	fn := func(w ResponseWriter, r *Request) {
+		if pr, ok := p.get(r.URL.Path); ok {
+			inputHandler(pr.code, pr.text, endpoint).ServeGemini(w, r)
+			return
+		}
+		endpoint.ServeGemini(w, r)
+	}
+	return HandlerFunc(fn)
+}
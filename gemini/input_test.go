@@ -0,0 +1,98 @@
+package gemini_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+func TestRequestInput(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+		err  error
+	}{
+		{"gemini://localhost/search", "", nil},
+		{"gemini://localhost/search?", "", nil},
+		{"gemini://localhost/search?gemini%20protocol", "gemini protocol", nil},
+		{"gemini://localhost/search?1+1%3D2", "1+1=2", nil},
+		{"gemini://localhost/search?caf%C3%A9", "café", nil},
+		{"gemini://localhost/search?100%", "", gemini.ErrInvalidInput},
+	}
+
+	for _, tt := range tests {
+		got, err := geminitest.NewRequest(tt.url).Input()
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %q, %v, want %q, %v", tt.url, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func echo(w gemini.ResponseWriter, r *gemini.Request) {
+	input, _ := r.Input()
+	gemini.Success(w, "text/plain")
+	w.Write([]byte(input))
+}
+
+func TestInputHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler gemini.Handler
+		url     string
+		header  string
+		body    string
+	}{
+		{"prompt", gemini.InputHandler("query?", gemini.HandlerFunc(echo)), "gemini://localhost/", "10 query?", ""},
+		{"sensitive prompt", gemini.SensitiveInputHandler("password?", gemini.HandlerFunc(echo)), "gemini://localhost/", "11 password?", ""},
+		{"answer", gemini.InputHandler("query?", gemini.HandlerFunc(echo)), "gemini://localhost/?a%20b", "20 text/plain", "a b"},
+		{"malformed", gemini.InputHandler("query?", gemini.HandlerFunc(echo)), "gemini://localhost/?%zz", "59 " + gemini.ErrInvalidInput.Error(), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := geminitest.NewRecorder()
+			tt.handler.ServeGemini(rec, geminitest.NewRequest(tt.url))
+			if rec.Header() != tt.header || rec.Body.String() != tt.body {
+				t.Errorf("got %q %q, want %q %q", rec.Header(), rec.Body.String(), tt.header, tt.body)
+			}
+		})
+	}
+}
+
+func TestMuxPrompt(t *testing.T) {
+	var seen []string
+	mux := gemini.NewMux()
+	mux.Use(func(next gemini.Handler) gemini.Handler {
+		return gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+			seen = append(seen, r.URL.Path)
+			next.ServeGemini(w, r)
+		})
+	})
+	mux.Prompt("/search", "search for?")
+	handler := mux.Handle(gemini.HandlerFunc(echo))
+	mux.SensitivePrompt("/login", "password?")
+
+	tests := []struct {
+		url    string
+		header string
+	}{
+		{"gemini://localhost/search", "10 search for?"},
+		{"gemini://localhost/search?gemini", "20 text/plain"},
+		{"gemini://localhost/login", "11 password?"},
+		{"gemini://localhost/other", "20 text/plain"},
+	}
+
+	for _, tt := range tests {
+		rec := geminitest.NewRecorder()
+		handler.ServeGemini(rec, geminitest.NewRequest(tt.url))
+		if rec.Header() != tt.header {
+			t.Errorf("%s: got %q, want %q", tt.url, rec.Header(), tt.header)
+		}
+	}
+
+	if len(seen) != len(tests) {
+		t.Errorf("middleware saw %d requests, want %d", len(seen), len(tests))
+	}
+}
@@ -37,8 +37,15 @@ func NewInterceptor(responseWriter ResponseWriter) (m *Interceptor) {
 	return
 }
 
-// WriteHeader writes the cached status code and tracks this call as change
+// WriteHeader writes the cached status code and tracks this call as change. Like the connection
+// writer it rejects invalid headers and headers after the first one.
 func (m *Interceptor) WriteHeader(code int, message string) (int, error) {
+	if m.hasHeader {
+		return 0, ErrHeaderWritten
+	}
+	if err := ValidateHeader(code, message); err != nil {
+		return 0, err
+	}
 	m.hasHeader = true
 	m.Code = code
 	m.Meta = message
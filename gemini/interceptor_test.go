@@ -0,0 +1,25 @@
+package gemini
+
+import (
+	"testing"
+)
+
+func TestInterceptorWriteHeader(t *testing.T) {
+	m := NewInterceptor(nil)
+	if _, err := m.WriteHeader(200, "text/gemini"); err != ErrInvalidStatus {
+		t.Errorf("invalid status: got %v, want %v", err, ErrInvalidStatus)
+	}
+	if m.HasHeader() {
+		t.Error("invalid header recorded")
+	}
+
+	if _, err := m.WriteHeader(StatusSuccess, "text/gemini"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.WriteHeader(StatusNotFound, "not found"); err != ErrHeaderWritten {
+		t.Errorf("second header: got %v, want %v", err, ErrHeaderWritten)
+	}
+	if m.Code != StatusSuccess || m.Meta != "text/gemini" {
+		t.Errorf("got %d %q, want the first header", m.Code, m.Meta)
+	}
+}
@@ -0,0 +1,152 @@
+package gemini
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KnownHost is a pinned server certificate.
+type KnownHost struct {
+	// Fingerprint is the SHA-256 fingerprint of the certificate, see Fingerprint.
+	Fingerprint string
+
+	// Expires is the end of the certificates validity. A changed certificate is accepted once
+	// the pinned one expired.
+	Expires time.Time
+}
+
+// KnownHosts stores certificate pins for trust on first use verification by the Client. Hosts
+// are identified by "hostname:port", hostnames are case insensitive. Implementations must be safe
+// for concurrent use.
+type KnownHosts interface {
+	Lookup(host string) (KnownHost, bool)
+	Add(host string, known KnownHost) error
+}
+
+// NewKnownHosts returns an in memory KnownHosts store.
+func NewKnownHosts() KnownHosts {
+	return &knownHosts{hosts: make(map[string]KnownHost)}
+}
+
+type knownHosts struct {
+	mu    sync.RWMutex
+	hosts map[string]KnownHost
+}
+
+func (k *knownHosts) Lookup(host string) (KnownHost, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	known, ok := k.hosts[strings.ToLower(host)]
+	return known, ok
+}
+
+func (k *knownHosts) Add(host string, known KnownHost) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.hosts[strings.ToLower(host)] = known
+	return nil
+}
+
+// KnownHostsFile is a file backed KnownHosts store. Each line holds a host, the fingerprint and
+// the expiry as unix timestamp separated by spaces:
+//
+//	example.org:1965 0a1b2c... 1735689600
+type KnownHostsFile struct {
+	knownHosts
+	path string
+}
+
+// OpenKnownHosts loads the known hosts from path. A missing file is created on the first Add.
+func OpenKnownHosts(path string) (*KnownHostsFile, error) {
+	k := &KnownHostsFile{
+		knownHosts: knownHosts{hosts: make(map[string]KnownHost)},
+		path:       path,
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("known hosts: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("known hosts: %s:%d: %w", path, n, ErrInvalidKnownHost)
+		}
+
+		expires, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("known hosts: %s:%d: %w", path, n, ErrInvalidKnownHost)
+		}
+
+		k.hosts[strings.ToLower(fields[0])] = KnownHost{Fingerprint: fields[1], Expires: time.Unix(expires, 0)}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("known hosts: %w", err)
+	}
+	return k, nil
+}
+
+// Add pins the certificate for host and persists all hosts to the file.
+func (k *KnownHostsFile) Add(host string, known KnownHost) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.hosts[strings.ToLower(host)] = known
+	return k.write()
+}
+
+// write replaces the file atomically, it is called with the lock held.
+func (k *KnownHostsFile) write() error {
+	hosts := make([]string, 0, len(k.hosts))
+	for host := range k.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var b strings.Builder
+	for _, host := range hosts {
+		known := k.hosts[host]
+		fmt.Fprintf(&b, "%s %s %d\n", host, known.Fingerprint, known.Expires.Unix())
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(k.path), ".known_hosts")
+	if err != nil {
+		return fmt.Errorf("known hosts: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("known hosts: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("known hosts: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), k.path); err != nil {
+		return fmt.Errorf("known hosts: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package gemini
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy decides how connections are treated once MaxOpenConns connections are in flight.
+type OverflowPolicy int
+
+const (
+	// OverflowQueue queues up to QueueSize connections for at most QueueTimeout each. Connections
+	// that don't fit in the queue or time out are answered with 41 SERVER UNAVAILABLE. Without a
+	// timeout they wait until a slot frees or the server shuts down.
+	OverflowQueue OverflowPolicy = iota
+
+	// OverflowSlowDown answers immediately with 44 SLOW DOWN.
+	OverflowSlowDown
+
+	// OverflowUnavailable answers immediately with 41 SERVER UNAVAILABLE.
+	OverflowUnavailable
+)
+
+const defaultSlowDown = time.Second
+
+// ParseOverflowPolicy returns the policy for the names "queue", "slowdown" and "unavailable".
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch name {
+	case "queue":
+		return OverflowQueue, nil
+	case "slowdown":
+		return OverflowSlowDown, nil
+	case "unavailable":
+		return OverflowUnavailable, nil
+	default:
+		return OverflowQueue, ErrUnknownOverflowPolicy
+	}
+}
+
+// admit hands the connection to a handler if a slot is free and applies the overflow policy
+// otherwise. Clients at their connection limit are answered with 44 right away, unless the
+// client address is only known from the PROXY header. It never blocks the accept loop.
+func (s *Server) admit(conn net.Conn) {
+	if !s.ProxyProtocol && s.limitsIP(conn) && !s.acquireIP(conn) {
+		s.log(LevelWarn, "connection limit per ip reached", "remote", conn.RemoteAddr())
+		go s.reject(conn, StatusSlowDown, s.slowDownMeta())
+
+		return
+	}
+
+	if s.tryAcquire() {
+		go s.handleConnection(conn)
+		return
+	}
+
+	switch s.OverflowPolicy {
+	case OverflowSlowDown:
+		go s.reject(conn, StatusSlowDown, s.slowDownMeta())
+	case OverflowUnavailable:
+		go s.reject(conn, StatusServerUnavailable, "")
+	default:
+		if atomic.AddInt32(&s.queued, 1) > int32(s.queueSize()) {
+			atomic.AddInt32(&s.queued, -1)
+			go s.reject(conn, StatusServerUnavailable, "")
+
+			return
+		}
+		s.setState(conn, StateQueued)
+		go s.enqueue(conn)
+	}
+}
+
+// enqueue waits for a free slot for up to the queue timeout, without a timeout until a slot
+// frees or the server shuts down.
+func (s *Server) enqueue(conn net.Conn) {
+	var timeout <-chan time.Time
+	if d := s.queueTimeout(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case s.sem <- struct{}{}:
+		atomic.AddInt32(&s.queued, -1)
+		if s.shuttingDown() {
+			// the slot was freed by a handler canceled by shutdown
+			s.release()
+			s.reject(conn, StatusServerUnavailable, "")
+
+			return
+		}
+		s.handleConnection(conn)
+	case <-s.ctx.Done():
+		atomic.AddInt32(&s.queued, -1)
+		s.reject(conn, StatusServerUnavailable, "")
+	case <-timeout:
+		atomic.AddInt32(&s.queued, -1)
+		s.count(&s.stats.TimedOut)
+		s.log(LevelWarn, "queue timeout", "remote", conn.RemoteAddr(), "timeout", s.queueTimeout(),
+			"inflight", len(s.sem), "max", s.MaxOpenConns)
+		s.reject(conn, StatusServerUnavailable, "")
+	}
+}
+
+func (s *Server) tryAcquire() bool {
+	if s.sem == nil {
+		return true
+	}
+
+	select {
+	case s.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) release() {
+	if s.sem != nil {
+		<-s.sem
+	}
+}
+
+// reject answers the connection with the status through the error handler without handling the
+// request. The unread request line is drained after the response, closing with unread data
+// would reset the connection and may discard the response before the client read it.
+func (s *Server) reject(conn net.Conn, code int, meta string) {
+	s.setState(conn, StateRejected)
+	defer func() {
+		conn.Close()
+		s.setState(conn, StateClosed)
+		s.releaseIP(conn)
+	}()
+
+	if s.ReadTimeout > 0 {
+		// bounds the handshake too
+		conn.SetDeadline(time.Now().Add(s.ReadTimeout))
+	}
+
+	w := newWriter(conn, s.WriteTimeout)
+	s.serveError(conn, w, nil, Error(code, errors.New(meta)))
+	if err := w.Flush(); err != nil {
+		return
+	}
+	drain(conn)
+}
+
+// drain half-closes the connection if supported and discards what the client still sends, at
+// most a request line and for a short time.
+func drain(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+
+	conn.SetReadDeadline(time.Now().Add(rejectDrainTimeout))
+	io.Copy(ioutil.Discard, io.LimitReader(conn, int64(URLMaxBytes+len(Termination))))
+}
+
+// limitsIP reports whether the per-IP limit applies to conn. The remote address identifies the
+// client only for TLS connections and for connections with a PROXY header, other plaintext peers
+// are TLS terminators or unix socket peers.
+func (s *Server) limitsIP(conn net.Conn) bool {
+	if s.MaxConnsPerIP <= 0 {
+		return false
+	} else if _, unix := conn.RemoteAddr().(*net.UnixAddr); unix {
+		return false
+	} else if s.ProxyProtocol {
+		return true
+	}
+
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
+
+// acquireIP reserves a connection slot for the client address of conn, it reports false if the
+// client is at its limit. The slot is held until releaseIP.
+func (s *Server) acquireIP(conn net.Conn) bool {
+	ip := remoteIP(conn.RemoteAddr().String())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.connsPerIP == nil {
+		s.connsPerIP = make(map[string]int)
+		s.connIPs = make(map[net.Conn]string)
+	}
+
+	if s.connsPerIP[ip] >= s.MaxConnsPerIP {
+		return false
+	}
+	s.connsPerIP[ip]++
+	s.connIPs[conn] = ip
+	return true
+}
+
+// releaseIP frees the slot held by conn, if any.
+func (s *Server) releaseIP(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ip, ok := s.connIPs[conn]
+	if !ok {
+		return
+	}
+	delete(s.connIPs, conn)
+
+	if s.connsPerIP[ip]--; s.connsPerIP[ip] <= 0 {
+		delete(s.connsPerIP, ip)
+	}
+}
+
+func (s *Server) queueSize() int {
+	if s.QueueSize > 0 {
+		return s.QueueSize
+	}
+	return s.MaxOpenConns
+}
+
+func (s *Server) queueTimeout() time.Duration {
+	if s.QueueTimeout > 0 {
+		return s.QueueTimeout
+	}
+	return s.ReadTimeout
+}
+
+func (s *Server) slowDownMeta() string {
+	d := s.SlowDown
+	if d <= 0 {
+		d = defaultSlowDown
+	}
+
+	seconds := int(d.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// remoteIP returns the host part of the remote address, or the address if it has none, e.g. for
+// unix sockets.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,233 @@
+package gemini_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+// queuedRequest requests the root of the server at addr in the background and sends the response
+// header to the returned channel.
+func queuedRequest(t *testing.T, addr string) <-chan string {
+	t.Helper()
+
+	header := make(chan string, 1)
+	go func() {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			header <- err.Error()
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("gemini://" + addr + "/" + gemini.Termination))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			header <- err.Error()
+			return
+		}
+		header <- strings.TrimSuffix(line, gemini.Termination)
+	}()
+	return header
+}
+
+func TestQueueWithoutTimeout(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s := geminitest.NewUnstartedServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		gemini.Success(w, "text/plain")
+	}))
+	s.Config.MaxOpenConns = 1
+	s.Config.ReadTimeout = 0
+	s.Start()
+	defer s.Close()
+
+	addr := s.Listener.Addr().String()
+	first := queuedRequest(t, addr)
+	<-started
+
+	// without a queue timeout the second connection waits for the slot
+	second := queuedRequest(t, addr)
+	select {
+	case header := <-second:
+		t.Fatalf("queued connection answered before a slot freed: %q", header)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	if header := <-first; !strings.HasPrefix(header, "20") {
+		t.Errorf("expected 20, got %q", header)
+	}
+	<-started
+	release <- struct{}{}
+	if header := <-second; !strings.HasPrefix(header, "20") {
+		t.Errorf("expected 20 for queued connection, got %q", header)
+	}
+
+	// holding the slot again, a queued connection is answered with 41 on shutdown
+	third := queuedRequest(t, addr)
+	<-started
+	fourth := queuedRequest(t, addr)
+	time.Sleep(50 * time.Millisecond)
+
+	s.Close()
+	if header := <-fourth; !strings.HasPrefix(header, "41") {
+		t.Errorf("expected 41 for queued connection on shutdown, got %q", header)
+	}
+	<-third
+}
+
+func TestMaxConnsPerIP(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s := geminitest.NewUnstartedServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		started <- struct{}{}
+		<-release
+		gemini.Success(w, "text/plain")
+	}))
+	s.Config.MaxConnsPerIP = 1
+	s.Start()
+	defer s.Close()
+
+	addr := s.Listener.Addr().String()
+	first := queuedRequest(t, addr)
+	<-started
+
+	// the second connection is refused before it sends a request
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	header, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != "44 1"+gemini.Termination {
+		t.Errorf("expected 44 1, got %q", header)
+	}
+
+	close(release)
+	if header := <-first; !strings.HasPrefix(header, "20") {
+		t.Errorf("expected 20, got %q", header)
+	}
+
+	// the slot is freed once the server closed the first connection
+	header = ""
+	for i := 0; i < 100 && !strings.HasPrefix(header, "20"); i++ {
+		time.Sleep(10 * time.Millisecond)
+		header = <-queuedRequest(t, addr)
+	}
+	if !strings.HasPrefix(header, "20") {
+		t.Errorf("expected 20 after the first connection closed, got %q", header)
+	}
+}
+
+func TestRejectDrainsRequest(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s := geminitest.NewUnstartedServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		started <- struct{}{}
+		<-release
+		gemini.Success(w, "text/plain")
+	}))
+	s.Config.MaxOpenConns = 1
+	s.Config.OverflowPolicy = gemini.OverflowSlowDown
+	s.Config.Plaintext = true
+	s.Start()
+	defer s.Close()
+	defer close(release)
+
+	addr := s.Listener.Addr().String()
+	go func() {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			defer conn.Close()
+			conn.Write([]byte("gemini://" + addr + "/" + gemini.Termination))
+			ioutil.ReadAll(conn)
+		}
+	}()
+	<-started
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the request line is unread when the server answered, closing must not reset the answer
+	conn.Write([]byte("gemini://" + addr + "/" + gemini.Termination))
+	time.Sleep(50 * time.Millisecond)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "44 1"+gemini.Termination {
+		t.Errorf("expected 44 1, got %q", resp)
+	}
+}
+
+func TestMaxConnsPerIPPlaintext(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s := geminitest.NewUnstartedServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		started <- struct{}{}
+		<-release
+		gemini.Success(w, "text/plain")
+	}))
+	s.Config.MaxConnsPerIP = 1
+	s.Config.Plaintext = true
+	s.Start()
+	defer s.Close()
+
+	// all peers share the address of the TLS terminator, the limit doesn't apply
+	addr := s.Listener.Addr().String()
+	responses := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				responses <- err.Error()
+				return
+			}
+			defer conn.Close()
+
+			conn.Write([]byte("gemini://" + addr + "/" + gemini.Termination))
+			body, _ := ioutil.ReadAll(conn)
+			responses <- string(body)
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case header := <-responses:
+			t.Fatalf("connection %d refused: %q", i, header)
+		case <-time.After(time.Second):
+			t.Fatalf("connection %d not handled", i)
+		}
+	}
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if header := <-responses; !strings.HasPrefix(header, "20") {
+			t.Errorf("expected 20, got %q", header)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package gemini
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is the severity of a log event.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelName = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelName[l]
+}
+
+// Logger is a leveled logger with key value pairs as fields, e.g.
+//
+//	logger.Log(LevelWarn, "server read timeout", "remote", "127.0.0.1:51234")
+//
+// Implementations must be safe for concurrent use. Adapt it to forward server events to a
+// structured logging pipeline.
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+// StdLogger adapts a standard library logger. Events at or above the minimum level are printed as
+// "gmifs: <level> <msg> key=value ...".
+func StdLogger(logger *log.Logger, min Level) Logger {
+	return &stdLogger{logger: logger, min: min}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	min    Level
+}
+
+func (l *stdLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < l.min {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("gmifs: ")
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%q", keyvals[i], fmt.Sprint(value))
+	}
+
+	l.logger.Println(b.String())
+}
@@ -6,6 +6,7 @@ type Middleware func(Handler) Handler
 type Mux struct {
 	middlewares []Middleware
 	handler     Handler
+	prompts     prompts
 }
 
 func NewMux() *Mux {
@@ -17,8 +18,20 @@ func (m *Mux) Use(handlers ...Middleware) {
 	m.middlewares = append(m.middlewares, handlers...)
 }
 
+// Prompt registers an input prompt for requests to path. Requests without a query are answered
+// with 10 and the prompt after passing the middlewares, the endpoint only sees answers. Prompts
+// may be registered before or after Handle.
+func (m *Mux) Prompt(path, prompt string) {
+	m.prompts.set(path, StatusInput, prompt)
+}
+
+// SensitivePrompt is like Prompt but answers with 11 for input the client should not echo.
+func (m *Mux) SensitivePrompt(path, prompt string) {
+	m.prompts.set(path, StatusSensitiveInput, prompt)
+}
+
 func (m *Mux) Handle(endpoint Handler) Handler {
-	m.handler = chain(m.middlewares, endpoint)
+	m.handler = chain(m.middlewares, m.prompts.handler(endpoint))
 	return m.handler
 }
 
@@ -0,0 +1,143 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultProxyMaxBytes limits upstream response bodies if Proxy.MaxBytes is zero.
+	DefaultProxyMaxBytes = 4 << 20
+
+	// DefaultProxyTimeout bounds upstream requests if Proxy.Timeout is zero.
+	DefaultProxyTimeout = 30 * time.Second
+)
+
+// Proxy forwards requests for allowlisted gemini hosts upstream and relays the responses. Set it
+// as Server.Proxy to serve requests for these hosts instead of refusing them with 53. Upstream
+// failures, timeouts and responses exceeding MaxBytes are answered with 43.
+type Proxy struct {
+	// Hosts lists the upstream hosts as "hostname:port", a bare "hostname" is the default port
+	// 1965.
+	Hosts []string
+
+	// Client fetches upstream, redirects are always relayed to the client instead of followed.
+	// If nil, upstream certificates are pinned on first use in memory.
+	Client *Client
+
+	// MaxBytes limits the response body, DefaultProxyMaxBytes if zero. Bodies are buffered up to
+	// the limit, so that oversized responses are still answered with 43.
+	MaxBytes int64
+
+	// Timeout bounds the upstream request including reading the body, DefaultProxyTimeout if
+	// zero.
+	Timeout time.Duration
+
+	once   sync.Once
+	client *Client
+}
+
+// Allowed reports whether requests for u are forwarded.
+func (p *Proxy) Allowed(u *url.URL) bool {
+	if u == nil || u.Scheme != "gemini" {
+		return false
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(defaultPort)
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	for _, host := range p.Hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(defaultPort))
+		}
+		if strings.EqualFold(host, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// upstream returns the client, the pins of the default client live as long as the proxy.
+func (p *Proxy) upstream() *Client {
+	p.once.Do(func() {
+		var c Client
+		if p.Client != nil {
+			c = *p.Client
+		} else {
+			c.KnownHosts = NewKnownHosts()
+		}
+		c.MaxRedirects = -1
+		p.client = &c
+	})
+	return p.client
+}
+
+func (p *Proxy) ServeGemini(w ResponseWriter, r *Request) {
+	if !p.Allowed(r.URL) {
+		w.WriteHeader(StatusProxyRequestRefused, "proxy request refused")
+		return
+	}
+
+	timeout, maxBytes := p.Timeout, p.MaxBytes
+	if timeout == 0 {
+		timeout = DefaultProxyTimeout
+	}
+	if maxBytes == 0 {
+		maxBytes = DefaultProxyMaxBytes
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	req, err := NewRequest(r.URL.String())
+	if err != nil {
+		w.WriteHeader(StatusProxyError, "invalid upstream url")
+		return
+	}
+
+	rsp, err := p.upstream().Do(req.WithContext(ctx))
+	if err != nil {
+		w.WriteHeader(StatusProxyError, proxyErrorMeta(ctx, err))
+		return
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(rsp.Body, maxBytes+1))
+	if err != nil {
+		w.WriteHeader(StatusProxyError, proxyErrorMeta(ctx, err))
+		return
+	} else if int64(len(body)) > maxBytes {
+		w.WriteHeader(StatusProxyError, "upstream response too large")
+		return
+	}
+
+	if _, err := w.WriteHeader(rsp.Status, rsp.Meta); err != nil {
+		w.WriteHeader(StatusProxyError, "invalid upstream response")
+		return
+	}
+	w.Write(body)
+}
+
+// proxyErrorMeta describes an upstream failure without internal details.
+func proxyErrorMeta(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "upstream timeout"
+	case errors.Is(err, ErrCertificateChanged):
+		return "upstream certificate changed"
+	case errors.Is(err, ErrInvalidResponse):
+		return "invalid upstream response"
+	default:
+		return "upstream unreachable"
+	}
+}
@@ -0,0 +1,145 @@
+package gemini_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+// proxyRequest sends the raw request line to the server at addr and returns the response.
+func proxyRequest(t *testing.T, addr, rawurl string) (string, string) {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(rawurl + gemini.Termination)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(r)
+	return strings.TrimSuffix(header, gemini.Termination), string(body)
+}
+
+func TestProxyAllowed(t *testing.T) {
+	p := &gemini.Proxy{Hosts: []string{"example.org", "Example.net:1966", "::1", "[2001:db8::1]:1966"}}
+
+	tests := []struct {
+		rawurl  string
+		allowed bool
+	}{
+		{rawurl: "gemini://example.org/", allowed: true},
+		{rawurl: "gemini://EXAMPLE.org:1965/", allowed: true},
+		{rawurl: "gemini://example.org:1966/"},
+		{rawurl: "gemini://example.net:1966/", allowed: true},
+		{rawurl: "gemini://example.net/"},
+		{rawurl: "gemini://[::1]/", allowed: true},
+		{rawurl: "gemini://[::1]:22/"},
+		{rawurl: "gemini://[2001:db8::1]:1966/", allowed: true},
+		{rawurl: "gemini://[2001:db8::1]/"},
+		{rawurl: "titan://example.org/"},
+		{rawurl: "gemini://example.com/"},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := p.Allowed(u); allowed != tt.allowed {
+			t.Errorf("%s: expected allowed %v, got %v", tt.rawurl, tt.allowed, allowed)
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	upstream := geminitest.NewServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			gemini.Redirect(w, "/")
+		case "/large":
+			gemini.Success(w, "text/plain")
+			w.Write([]byte(strings.Repeat("a", 17)))
+		case "/slow":
+			<-r.Context().Done()
+		default:
+			gemini.Success(w, "text/plain")
+			w.Write([]byte("upstream " + r.URL.Path))
+		}
+	}))
+	defer upstream.Close()
+
+	// a port nobody listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := l.Addr().String()
+	l.Close()
+
+	front := geminitest.NewUnstartedServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		gemini.Success(w, "text/plain")
+		w.Write([]byte("front"))
+	}))
+	front.Config.Hostname = "localhost"
+	front.Config.Proxy = &gemini.Proxy{
+		Hosts:    []string{upstream.Listener.Addr().String(), down},
+		MaxBytes: 16,
+		Timeout:  200 * time.Millisecond,
+	}
+	var proxied int32
+	front.Config.ProxyHandler = gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		atomic.AddInt32(&proxied, 1)
+		front.Config.Proxy.ServeGemini(w, r)
+	})
+	front.Start()
+	defer front.Close()
+
+	port := front.Listener.Addr().(*net.TCPAddr).Port
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		body   string
+	}{
+		{"own host", "gemini://localhost:" + strconv.Itoa(port) + "/", "20 text/plain", "front"},
+		{"relay", upstream.URL + "/page", "20 text/plain", "upstream /page"},
+		{"redirect", upstream.URL + "/moved", "30 /", ""},
+		{"too large", upstream.URL + "/large", "43 upstream response too large", ""},
+		{"timeout", upstream.URL + "/slow", "43 upstream timeout", ""},
+		{"unreachable", "gemini://" + down + "/", "43 upstream unreachable", ""},
+		{"not allowed", "gemini://example.org/", "53 gemini: host not served", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, body := proxyRequest(t, front.Listener.Addr().String(), tt.url)
+			if header != tt.header || body != tt.body {
+				t.Errorf("got %q %q, want %q %q", header, body, tt.header, tt.body)
+			}
+		})
+	}
+
+	// proxied requests pass the ProxyHandler, the own host and refused hosts don't
+	if n := atomic.LoadInt32(&proxied); n != 5 {
+		t.Errorf("expected 5 requests through the ProxyHandler, got %d", n)
+	}
+}
@@ -0,0 +1,208 @@
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	proxyV1MaxLength = 107
+	proxyV2HeaderLen = 16
+)
+
+var (
+	ErrInvalidProxyHeader = errors.New("gemini: invalid proxy protocol header")
+	ErrNoTrustedProxies   = errors.New("gemini: proxy protocol enabled without trusted proxies")
+
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyListener reads PROXY protocol v1 and v2 headers from connections of trusted sources and
+// reports the address of the original client as remote address. Connections of untrusted sources
+// are passed through untouched. Peers on unix sockets are trusted, access is controlled by file
+// permissions.
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return true
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, n := range l.trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn parses the optional proxy header lazily on the first read, which happens during the
+// TLS handshake and is bound by the servers deadlines.
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	mu     sync.Mutex
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address from the proxy header once it was read and the address
+// of the peer otherwise.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	var remote net.Addr
+	var err error
+
+	first, err := c.r.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	switch first[0] {
+	case proxyV1Signature[0]:
+		remote, err = readProxyV1(c.r)
+	case proxyV2Signature[0]:
+		remote, err = readProxyV2(c.r)
+	default:
+		// no header, e.g. a health check connecting directly
+		return
+	}
+
+	c.mu.Lock()
+	c.remote, c.err = remote, err
+	c.mu.Unlock()
+}
+
+// readProxyV1 parses the human readable header, e.g.
+// "PROXY TCP4 192.168.0.1 192.168.0.11 56324 1965\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidProxyHeader, err)
+		}
+
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte(Termination)) {
+			break
+		}
+	}
+
+	if !bytes.HasPrefix(line, proxyV1Signature) || !bytes.HasSuffix(line, []byte(Termination)) {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-len(Termination)]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	} else if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses the binary header. Only the source address of TCP over IPv4 and IPv6 is
+// used, TLVs are skipped.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxyHeader, err)
+	}
+
+	if !bytes.Equal(header[:len(proxyV2Signature)], proxyV2Signature) || header[12]>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	command, family := header[12]&0x0f, header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxyHeader, err)
+	}
+
+	// LOCAL command, e.g. health checks of the proxy itself
+	if command == 0 {
+		return nil, nil
+	} else if command != 1 {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	default:
+		// unsupported or unspecified family, keep the peer address
+		return nil, nil
+	}
+}
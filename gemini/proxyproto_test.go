@@ -0,0 +1,151 @@
+package gemini
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2 builds a v2 header with the given command, address family and payload.
+func proxyV2(command, family byte, payload []byte) string {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(payload)))
+	return string(append(header, payload...))
+}
+
+func TestProxyConn(t *testing.T) {
+	ipv4 := []byte{192, 168, 0, 1, 192, 168, 0, 11, 0xdc, 0x04, 0x07, 0xad}
+	ipv6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...),
+		0xdc, 0x04, 0x07, 0xad)
+	request := "gemini://localhost/\r\n"
+
+	tests := []struct {
+		name   string
+		header string
+		remote string // empty if the peer address is kept
+		err    bool
+	}{
+		{name: "V1TCP4", header: "PROXY TCP4 192.168.0.1 192.168.0.11 56324 1965\r\n",
+			remote: "192.168.0.1:56324"},
+		{name: "V1TCP6", header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 1965\r\n",
+			remote: "[2001:db8::1]:56324"},
+		{name: "V1Unknown", header: "PROXY UNKNOWN\r\n"},
+		{name: "V1UnknownAddresses", header: "PROXY UNKNOWN 192.168.0.1 192.168.0.11 56324 1965\r\n"},
+		{name: "V1InvalidIP", header: "PROXY TCP4 192.168.0 192.168.0.11 56324 1965\r\n", err: true},
+		{name: "V1InvalidPort", header: "PROXY TCP4 192.168.0.1 192.168.0.11 65536 1965\r\n", err: true},
+		{name: "V1MissingField", header: "PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n", err: true},
+		{name: "V1Truncated", header: "PROXY TCP4 192.168.0.1", err: true},
+		{name: "V1Oversized", header: "PROXY TCP6 " + strings.Repeat("0", proxyV1MaxLength) + "\r\n",
+			err: true},
+		{name: "V2ProxyIPv4", header: proxyV2(1, 0x11, ipv4), remote: "192.168.0.1:56324"},
+		{name: "V2ProxyIPv6", header: proxyV2(1, 0x21, ipv6), remote: "[2001:db8::1]:56324"},
+		{name: "V2ProxyTLV", header: proxyV2(1, 0x11, append(ipv4, 0x04, 0, 1, 0)),
+			remote: "192.168.0.1:56324"},
+		{name: "V2ProxyUnspec", header: proxyV2(1, 0x00, nil)},
+		{name: "V2Local", header: proxyV2(0, 0x11, ipv4)},
+		{name: "V2InvalidCommand", header: proxyV2(2, 0x11, ipv4), err: true},
+		{name: "V2InvalidVersion", header: strings.Replace(proxyV2(1, 0x11, ipv4), "\x21", "\x11", 1),
+			err: true},
+		{name: "V2ShortIPv4", header: proxyV2(1, 0x11, ipv4[:8]), err: true},
+		{name: "V2ShortIPv6", header: proxyV2(1, 0x21, ipv6[:32]), err: true},
+		{name: "V2TruncatedHeader", header: proxyV2(1, 0x11, ipv4)[:10], err: true},
+		{name: "V2TruncatedPayload", header: proxyV2(1, 0x11, ipv4)[:20], err: true},
+		{name: "NoHeader"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peer, other := net.Pipe()
+			defer peer.Close()
+			defer other.Close()
+
+			raw := tt.header
+			if !tt.err {
+				raw += request
+			}
+			conn := &proxyConn{Conn: peer, r: bufio.NewReader(strings.NewReader(raw))}
+
+			data, err := ioutil.ReadAll(conn)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidProxyHeader) {
+					t.Fatalf("expected ErrInvalidProxyHeader, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the header is consumed, the request follows untouched
+			if string(data) != request {
+				t.Errorf("expected %q after the header, got %q", request, data)
+			}
+
+			remote := conn.RemoteAddr().String()
+			if tt.remote == "" && remote != peer.RemoteAddr().String() {
+				t.Errorf("expected peer address, got %s", remote)
+			} else if tt.remote != "" && remote != tt.remote {
+				t.Errorf("expected %s, got %s", tt.remote, remote)
+			}
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	header := "PROXY TCP4 192.168.0.1 192.168.0.11 56324 1965\r\n"
+
+	tests := []struct {
+		name    string
+		trusted string
+		remote  string // empty for the peer address
+		data    string
+	}{
+		{name: "Trusted", trusted: "127.0.0.0/8", remote: "192.168.0.1:56324", data: "request"},
+		{name: "Untrusted", trusted: "10.0.0.0/8", data: header + "request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, trusted, _ := net.ParseCIDR(tt.trusted)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pl := &proxyListener{Listener: l, trusted: []*net.IPNet{trusted}}
+			defer pl.Close()
+
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.Write([]byte(header + "request"))
+			client.Close()
+
+			conn, err := pl.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			data, err := ioutil.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.data {
+				t.Errorf("expected %q, got %q", tt.data, data)
+			}
+
+			remote := conn.RemoteAddr().String()
+			if tt.remote == "" && remote != client.LocalAddr().String() {
+				t.Errorf("expected the peer address %s, got %s", client.LocalAddr(), remote)
+			} else if tt.remote != "" && remote != tt.remote {
+				t.Errorf("expected %s, got %s", tt.remote, remote)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package gemini
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultPort is assumed for request URLs without explicit port.
+const defaultPort = 1965
+
+// ParseRequest reads a request line from r and parses it according to the specification:
+//
+//	<URL><CR><LF>
+//
+// The URL must be absolute, at most URLMaxBytes long and must not contain userinfo or a fragment.
+// The limit is enforced while reading and r is never read past the terminating line feed, data
+// following the request line stays in r. Errors are of type *GmiError and carry the status the
+// client should be answered with. The partially parsed request is returned with the error if
+// available, e.g. for logging. If r is a connection with read deadline, a timeout is reported as
+// ErrReadTimeout with status 41.
+//
+// Titan upload requests are parsed as well, see Titan. Their Body reads the upload from r.
+func ParseRequest(r io.Reader) (*Request, error) {
+	line, err := ReadLine(r, URLMaxBytes, true)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Request{RequestURI: line}
+	if line == "" {
+		return req, Error(StatusBadRequest, ErrEmptyRequestURL)
+	} else if !utf8.ValidString(line) {
+		return req, Error(StatusBadRequest, ErrInvalidUtf8)
+	}
+
+	// the parse error quotes the raw line, don't echo it to the client.
+	u, err := url.Parse(line)
+	if err != nil {
+		return req, Error(StatusBadRequest, ErrInvalidURL)
+	}
+	req.URL = u
+
+	if u.Scheme == "titan" {
+		if err := parseTitan(req, r); err != nil {
+			return req, err
+		}
+	}
+
+	return req, validateRequest(req)
+}
+
+// ReadLine reads a line terminated by CRLF from r byte by byte, to never consume more than the
+// line. The line without terminator is limited to max bytes. Unless strict, a bare line feed
+// terminates the line too. Errors are of type *GmiError: ErrEmptyRequest if r ends before the
+// first byte, ErrMissingTermination if it ends within the line or, if strict, on a carriage return
+// without line feed, ErrHeaderTooLong beyond max and ErrReadTimeout with status 41 if a read
+// deadline passed. It is shared with the servers of other protocols, e.g. spartan and gopher.
+func ReadLine(r io.Reader, max int, strict bool) (string, error) {
+	buf := make([]byte, 0, 128)
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 0 && err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return "", Error(StatusServerUnavailable, ErrReadTimeout)
+			} else if len(buf) == 0 {
+				return "", Error(StatusBadRequest, ErrEmptyRequest)
+			}
+			return "", Error(StatusBadRequest, ErrMissingTermination)
+		} else if n == 0 {
+			continue
+		}
+
+		cr := len(buf) > 0 && buf[len(buf)-1] == '\r'
+		if b[0] == '\n' {
+			if cr {
+				return string(buf[:len(buf)-1]), nil
+			} else if strict {
+				return "", Error(StatusBadRequest, ErrMissingTermination)
+			}
+			return string(buf), nil
+		}
+
+		if strict && cr {
+			// carriage return without line feed
+			return "", Error(StatusBadRequest, ErrMissingTermination)
+		} else if len(buf) > max || len(buf) == max && b[0] != '\r' {
+			return "", Error(StatusBadRequest, ErrHeaderTooLong)
+		}
+		buf = append(buf, b[0])
+	}
+}
+
+func validateRequest(r *Request) error {
+	u := r.URL
+	if u.Scheme == "" {
+		return Error(StatusBadRequest, ErrMissingScheme)
+	} else if u.Scheme != "gemini" && u.Scheme != "titan" {
+		return Error(StatusProxyRequestRefused, ErrUnknownProtocol)
+	} else if u.Host == "" || u.Hostname() == "" {
+		return Error(StatusBadRequest, ErrInvalidHost)
+	} else if u.User != nil {
+		return Error(StatusBadRequest, ErrUserInfo)
+	} else if u.Fragment != "" || strings.Contains(r.RequestURI, "#") {
+		return Error(StatusBadRequest, ErrFragment)
+	} else if port := u.Port(); port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return Error(StatusBadRequest, ErrInvalidPort)
+		}
+	}
+
+	if u.Path == "" {
+		// This error is a redirect to the root of the capsule.
+		target := *u
+		target.Path = "/"
+		return Error(StatusRedirectPermanent, errors.New(target.String()))
+	} else if cleaned := path.Clean(u.Path); cleaned != u.Path {
+		// check valid alternative if unclean for directories
+		if cleaned != strings.TrimRight(u.Path, "/") {
+			return Error(StatusBadRequest, ErrInvalidPath)
+		}
+	}
+
+	return nil
+}
+
+// proxied reports whether the request is forwarded by the Proxy. Requests for the servers own
+// Hostname never are.
+func (s *Server) proxied(u *url.URL) bool {
+	if s.Proxy == nil || (s.Hostname != "" && strings.EqualFold(u.Hostname(), s.Hostname)) {
+		return false
+	}
+	return s.Proxy.Allowed(u)
+}
+
+// checkHost refuses requests for hosts other than the servers Hostname, if set, and for ports
+// other than the one the connection was accepted on. The port is not checked behind proxies.
+// Titan uploads are refused unless enabled.
+func (s *Server) checkHost(u *url.URL, local net.Addr) error {
+	if u.Scheme == "titan" && !s.Titan {
+		return Error(StatusProxyRequestRefused, ErrUnknownProtocol)
+	}
+
+	if s.Hostname != "" && !strings.EqualFold(u.Hostname(), s.Hostname) {
+		return Error(StatusProxyRequestRefused, ErrForeignHost)
+	}
+
+	port := u.Port()
+	addr, ok := local.(*net.TCPAddr)
+	if port == "" || !ok || s.Plaintext || s.ProxyProtocol {
+		return nil
+	}
+
+	if port != strconv.Itoa(addr.Port) {
+		return Error(StatusProxyRequestRefused, ErrForeignPort)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package gemini
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Conformance cases modelled on the gemini-diagnostics request checks.
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status int // zero for a valid request
+		meta   string
+	}{
+		{name: "Homepage", raw: "gemini://localhost/\r\n"},
+		{name: "PageWithQuery", raw: "gemini://localhost/search?gemini%20space\r\n"},
+		{name: "URLIncludePort", raw: "gemini://localhost:1965/\r\n"},
+		{name: "URLByIPAddress", raw: "gemini://127.0.0.1/\r\n"},
+		{name: "URLIPv6", raw: "gemini://[::1]:1965/\r\n"},
+		{name: "URLDirectorySlash", raw: "gemini://localhost/docs/\r\n"},
+		{name: "URLMaxSize", raw: "gemini://localhost/" + strings.Repeat("a", URLMaxBytes-19) + "\r\n"},
+		{name: "HomepageRedirect", raw: "gemini://localhost\r\n", status: StatusRedirectPermanent,
+			meta: "gemini://localhost/"},
+		{name: "URLAboveMaxSize", raw: "gemini://localhost/" + strings.Repeat("a", URLMaxBytes-18) + "\r\n",
+			status: StatusBadRequest},
+		{name: "URLEmpty", raw: "\r\n", status: StatusBadRequest},
+		{name: "URLRelative", raw: "/\r\n", status: StatusBadRequest},
+		{name: "URLRelativeHost", raw: "//localhost/\r\n", status: StatusBadRequest},
+		{name: "URLInvalid", raw: "gemini://local host/\r\n", status: StatusBadRequest,
+			meta: ErrInvalidURL.Error()},
+		{name: "URLInvalidMaxSize", raw: "gemini://local host/" + strings.Repeat("a", URLMaxBytes-20) + "\r\n",
+			status: StatusBadRequest, meta: ErrInvalidURL.Error()},
+		{name: "URLInvalidUTF8Byte", raw: "gemini://localhost/\xe2\x28\xa1\r\n", status: StatusBadRequest},
+		{name: "URLDotEscape", raw: "gemini://localhost/../../\r\n", status: StatusBadRequest},
+		{name: "URLDotSegment", raw: "gemini://localhost/docs/./index.gmi\r\n", status: StatusBadRequest},
+		{name: "URLUserInfo", raw: "gemini://user@localhost/\r\n", status: StatusBadRequest},
+		{name: "URLFragment", raw: "gemini://localhost/#top\r\n", status: StatusBadRequest},
+		{name: "URLEmptyHost", raw: "gemini:///\r\n", status: StatusBadRequest},
+		{name: "URLInvalidPort", raw: "gemini://localhost:99999/\r\n", status: StatusBadRequest},
+		{name: "URLSchemeHTTP", raw: "http://localhost/\r\n", status: StatusProxyRequestRefused},
+		{name: "URLSchemeHTTPS", raw: "https://localhost/\r\n", status: StatusProxyRequestRefused},
+		{name: "URLSchemeGopher", raw: "gopher://localhost/\r\n", status: StatusProxyRequestRefused},
+		{name: "RequestMissingCR", raw: "gemini://localhost/\n", status: StatusBadRequest},
+		{name: "RequestMissingLF", raw: "gemini://localhost/\r", status: StatusBadRequest},
+		{name: "RequestMissingCRLF", raw: "gemini://localhost/", status: StatusBadRequest},
+		{name: "RequestBareCR", raw: "gemini://local\rhost/\r\n", status: StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseRequest(strings.NewReader(tt.raw))
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if req.URL == nil || req.RequestURI != strings.TrimSuffix(tt.raw, Termination) {
+					t.Fatalf("unexpected request: %+v", req)
+				}
+				return
+			}
+
+			var gmierr *GmiError
+			if !errors.As(err, &gmierr) {
+				t.Fatalf("expected status %d, got error %v", tt.status, err)
+			}
+			if gmierr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %v", tt.status, gmierr.Code, err)
+			}
+			if tt.meta != "" && gmierr.Error() != tt.meta {
+				t.Errorf("expected meta %q, got %q", tt.meta, gmierr.Error())
+			}
+		})
+	}
+}
+
+func TestParseRequestEmpty(t *testing.T) {
+	_, err := ParseRequest(strings.NewReader(""))
+	if !errors.Is(err, ErrEmptyRequest) {
+		t.Fatalf("expected %v, got %v", ErrEmptyRequest, err)
+	}
+}
+
+func TestParseRequestDoesNotReadPastLineFeed(t *testing.T) {
+	r := strings.NewReader("gemini://localhost/upload\r\nbody")
+	if _, err := ParseRequest(r); err != nil {
+		t.Fatal(err)
+	}
+
+	rest, _ := ioutil.ReadAll(r)
+	if string(rest) != "body" {
+		t.Fatalf("expected remaining body, got %q", rest)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	tests := []struct {
+		raw    string
+		strict bool
+		line   string
+		err    error
+	}{
+		{raw: "abcd\r\n", strict: true, line: "abcd"},
+		{raw: "abcd\n", strict: true, err: ErrMissingTermination},
+		{raw: "ab\rcd\r\n", strict: true, err: ErrMissingTermination},
+		{raw: "abcd\r", strict: true, err: ErrMissingTermination},
+		{raw: "abcde\r\n", strict: true, err: ErrHeaderTooLong},
+		{raw: "", strict: true, err: ErrEmptyRequest},
+		{raw: "abcd\r\n", line: "abcd"},
+		{raw: "abcd\n", line: "abcd"},
+		{raw: "ab\rc\n", line: "ab\rc"},
+		{raw: "abcd\rx\n", err: ErrHeaderTooLong},
+		{raw: "abcde\n", err: ErrHeaderTooLong},
+		{raw: "abc", err: ErrMissingTermination},
+	}
+
+	for _, tt := range tests {
+		line, err := ReadLine(strings.NewReader(tt.raw), 4, tt.strict)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%q strict %v: expected %v, got %v", tt.raw, tt.strict, tt.err, err)
+			}
+			continue
+		}
+
+		if err != nil || line != tt.line {
+			t.Errorf("%q strict %v: got %q, %v", tt.raw, tt.strict, line, err)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1965}
+	tests := []struct {
+		name   string
+		server *Server
+		url    string
+		status int
+	}{
+		{name: "Host", server: &Server{Hostname: "localhost"}, url: "gemini://localhost/"},
+		{name: "HostCase", server: &Server{Hostname: "localhost"}, url: "gemini://LOCALHOST/"},
+		{name: "AnyHost", server: &Server{}, url: "gemini://example.org/"},
+		{name: "Port", server: &Server{Hostname: "localhost"}, url: "gemini://localhost:1965/"},
+		{name: "URLWrongHost", server: &Server{Hostname: "localhost"}, url: "gemini://example.org/",
+			status: StatusProxyRequestRefused},
+		{name: "URLWrongPort", server: &Server{Hostname: "localhost"}, url: "gemini://localhost:1966/",
+			status: StatusProxyRequestRefused},
+		{name: "PortBehindProxy", server: &Server{ProxyProtocol: true}, url: "gemini://localhost:1966/"},
+		{name: "Titan", server: &Server{Titan: true}, url: "titan://localhost/"},
+		{name: "TitanDisabled", server: &Server{}, url: "titan://localhost/", status: StatusProxyRequestRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.server.checkHost(u, local)
+			var gmierr *GmiError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.status != 0 && (!errors.As(err, &gmierr) || gmierr.Code != tt.status):
+				t.Fatalf("expected status %d, got %v", tt.status, err)
+			}
+		})
+	}
+}
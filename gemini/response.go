@@ -0,0 +1,122 @@
+package gemini
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ValidateHeader checks that code is a two digit status between 10 and 69 and that message fits
+// into META, at most 1024 bytes and without line breaks.
+func ValidateHeader(code int, message string) error {
+	if code < StatusInput || code > 69 {
+		return ErrInvalidStatus
+	}
+	if len(message) > URLMaxBytes {
+		return ErrMetaTooLong
+	}
+	if strings.ContainsAny(message, "\r\n") {
+		return ErrInvalidMeta
+	}
+	return nil
+}
+
+// Success writes a 20 header with the mimetype, text/gemini if empty. The body may follow.
+func Success(w ResponseWriter, mimeType string) error {
+	if mimeType == "" {
+		mimeType = MimeType
+	}
+	return writeHeader(w, StatusSuccess, mimeType)
+}
+
+// Input asks the client for a line of input with prompt, answered with 10.
+func Input(w ResponseWriter, prompt string) error {
+	return writeHeader(w, StatusInput, prompt)
+}
+
+// SensitiveInput is like Input but the client should not echo the input, answered with 11.
+func SensitiveInput(w ResponseWriter, prompt string) error {
+	return writeHeader(w, StatusSensitiveInput, prompt)
+}
+
+// Redirect answers with a temporary redirect, 30, to the absolute or relative url.
+func Redirect(w ResponseWriter, url string) error {
+	return writeHeader(w, StatusRedirectTemporary, url)
+}
+
+// PermanentRedirect answers with a permanent redirect, 31, to the absolute or relative url.
+func PermanentRedirect(w ResponseWriter, url string) error {
+	return writeHeader(w, StatusRedirectPermanent, url)
+}
+
+// NotFound answers with 51.
+func NotFound(w ResponseWriter) error {
+	return writeHeader(w, StatusNotFound, "not found")
+}
+
+// Gone answers with 52, the resource is no longer available.
+func Gone(w ResponseWriter) error {
+	return writeHeader(w, StatusGone, "gone")
+}
+
+// SlowDown answers with 44 and asks the client to wait for seconds before the next request.
+func SlowDown(w ResponseWriter, seconds int) error {
+	return writeHeader(w, StatusSlowDown, strconv.Itoa(seconds))
+}
+
+// CertificateRequired answers with 60, the client should retry with a certificate.
+func CertificateRequired(w ResponseWriter, message string) error {
+	return writeHeader(w, StatusClientCertificateRequired, message)
+}
+
+// ServeError answers with the status and message of a *GmiError in err's chain and with 40 for
+// any other error, without exposing its text to the client. If the message is no valid META,
+// e.g. too long, the status text is sent instead and redirects become 59.
+func ServeError(w ResponseWriter, err error) error {
+	var gmierr *GmiError
+	if !errors.As(err, &gmierr) {
+		return writeHeader(w, StatusTemporaryFailure, "internal")
+	}
+
+	code, meta := gmierr.Code, gmierr.Error()
+	if ValidateHeader(code, "") != nil {
+		code, meta = StatusTemporaryFailure, "internal"
+	} else if ValidateHeader(code, meta) != nil && code/10 == 3 {
+		// the status text is no redirect target.
+		code, meta = StatusBadRequest, StatusText(StatusBadRequest)
+	} else if ValidateHeader(code, meta) != nil {
+		meta = StatusText(code)
+	}
+	return writeHeader(w, code, meta)
+}
+
+var statusText = map[int]string{
+	StatusInput:                     "input",
+	StatusSensitiveInput:            "sensitive input",
+	StatusSuccess:                   "success",
+	StatusRedirectTemporary:         "redirect",
+	StatusRedirectPermanent:         "permanent redirect",
+	StatusTemporaryFailure:          "temporary failure",
+	StatusServerUnavailable:         "server unavailable",
+	StatusCgiError:                  "cgi error",
+	StatusProxyError:                "proxy error",
+	StatusSlowDown:                  "slow down",
+	StatusPermanentFailure:          "permanent failure",
+	StatusNotFound:                  "not found",
+	StatusGone:                      "gone",
+	StatusProxyRequestRefused:       "proxy request refused",
+	StatusBadRequest:                "bad request",
+	StatusClientCertificateRequired: "client certificate required",
+	StatusCertificateNotAuthorized:  "certificate not authorized",
+	StatusCertificateNotValid:       "certificate not valid",
+}
+
+// StatusText returns a text for the status code, empty if the code is unknown.
+func StatusText(code int) string {
+	return statusText[code]
+}
+
+func writeHeader(w ResponseWriter, code int, message string) error {
+	_, err := w.WriteHeader(code, message)
+	return err
+}
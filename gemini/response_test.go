@@ -0,0 +1,82 @@
+package gemini_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+func TestResponseHelpers(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w gemini.ResponseWriter) error
+		want  string
+	}{
+		{"success", func(w gemini.ResponseWriter) error { return gemini.Success(w, "") }, "20 " + gemini.MimeType},
+		{"success mime", func(w gemini.ResponseWriter) error { return gemini.Success(w, "image/png") }, "20 image/png"},
+		{"input", func(w gemini.ResponseWriter) error { return gemini.Input(w, "name?") }, "10 name?"},
+		{"sensitive input", func(w gemini.ResponseWriter) error { return gemini.SensitiveInput(w, "pin?") }, "11 pin?"},
+		{"redirect", func(w gemini.ResponseWriter) error { return gemini.Redirect(w, "/new") }, "30 /new"},
+		{"permanent redirect", func(w gemini.ResponseWriter) error { return gemini.PermanentRedirect(w, "/new") }, "31 /new"},
+		{"not found", gemini.NotFound, "51 not found"},
+		{"gone", gemini.Gone, "52 gone"},
+		{"slow down", func(w gemini.ResponseWriter) error { return gemini.SlowDown(w, 5) }, "44 5"},
+		{"certificate required", func(w gemini.ResponseWriter) error { return gemini.CertificateRequired(w, "login") }, "60 login"},
+		{"gmi error", func(w gemini.ResponseWriter) error {
+			return gemini.ServeError(w, gemini.Error(gemini.StatusBadRequest, gemini.ErrFragment))
+		}, "59 gemini: fragment not allowed"},
+		{"gmi error too long", func(w gemini.ResponseWriter) error {
+			return gemini.ServeError(w, gemini.Error(gemini.StatusBadRequest, errors.New(strings.Repeat("a", 1025))))
+		}, "59 bad request"},
+		{"gmi error line break", func(w gemini.ResponseWriter) error {
+			return gemini.ServeError(w, gemini.Error(gemini.StatusNotFound, errors.New("a\r\n20 text/gemini")))
+		}, "51 not found"},
+		{"gmi error redirect too long", func(w gemini.ResponseWriter) error {
+			return gemini.ServeError(w, gemini.Error(gemini.StatusRedirectPermanent, errors.New("/"+strings.Repeat("a", 1024))))
+		}, "59 bad request"},
+		{"gmi error invalid status", func(w gemini.ResponseWriter) error {
+			return gemini.ServeError(w, gemini.Error(200, errors.New("ok")))
+		}, "40 internal"},
+		{"other error", func(w gemini.ResponseWriter) error {
+			return gemini.ServeError(w, errors.New("open /srv/secret: permission denied"))
+		}, "40 internal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := geminitest.NewRecorder()
+			if err := tt.write(rec); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Header() != tt.want {
+				t.Errorf("got %q, want %q", rec.Header(), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	tests := []struct {
+		code int
+		meta string
+		want error
+	}{
+		{20, "text/gemini", nil},
+		{69, "", nil},
+		{9, "", gemini.ErrInvalidStatus},
+		{70, "", gemini.ErrInvalidStatus},
+		{200, "", gemini.ErrInvalidStatus},
+		{30, "/" + strings.Repeat("a", 1023), nil},
+		{30, "/" + strings.Repeat("a", 1024), gemini.ErrMetaTooLong},
+		{20, "text/gemini\r\n20 text/plain", gemini.ErrInvalidMeta},
+	}
+
+	for _, tt := range tests {
+		if err := gemini.ValidateHeader(tt.code, tt.meta); err != tt.want {
+			t.Errorf("ValidateHeader(%d, %.20q): got %v, want %v", tt.code, tt.meta, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package gemini
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TitanMimeType is assumed for titan uploads without mime parameter.
+const TitanMimeType = "text/gemini"
+
+// Titan holds the parameters of a titan upload request:
+//
+//	titan://host/path;size=<bytes>;mime=<type>;token=<token><CR><LF><body>
+//
+// Size is required, a size of zero asks to delete the resource. Mime defaults to TitanMimeType.
+type Titan struct {
+	Size  int64
+	Mime  string
+	Token string
+}
+
+// parseTitan strips the titan parameters from the request path and sets Titan and a body
+// limited to the announced size, read from r.
+func parseTitan(req *Request, r io.Reader) error {
+	u := req.URL
+	i := strings.IndexByte(u.Path, ';')
+	if i < 0 {
+		return Error(StatusBadRequest, ErrInvalidTitanParams)
+	}
+
+	params := u.Path[i+1:]
+	u.Path, u.RawPath = u.Path[:i], ""
+	if u.Path == "" {
+		return Error(StatusBadRequest, ErrInvalidPath)
+	}
+
+	titan := &Titan{Size: -1, Mime: TitanMimeType}
+	for _, param := range strings.Split(params, ";") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return Error(StatusBadRequest, ErrInvalidTitanParams)
+		}
+
+		switch kv[0] {
+		case "size":
+			size, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || size < 0 {
+				return Error(StatusBadRequest, ErrInvalidTitanParams)
+			}
+			titan.Size = size
+		case "mime":
+			if kv[1] != "" {
+				titan.Mime = kv[1]
+			}
+		case "token":
+			titan.Token = kv[1]
+		}
+	}
+
+	if titan.Size < 0 {
+		return Error(StatusBadRequest, ErrInvalidTitanParams)
+	}
+
+	req.Titan = titan
+	req.Body = io.LimitReader(r, titan.Size)
+	return nil
+}
+
+// redactToken replaces the values of titan token parameters in a request line, so that tokens
+// don't end up in logs.
+func redactToken(uri string) string {
+	const param = ";token="
+
+	var b strings.Builder
+	for {
+		i := strings.Index(uri, param)
+		if i < 0 {
+			b.WriteString(uri)
+			return b.String()
+		}
+		b.WriteString(uri[:i+len(param)])
+		b.WriteString("REDACTED")
+
+		uri = uri[i+len(param):]
+		if j := strings.IndexAny(uri, ";?"); j >= 0 {
+			uri = uri[j:]
+		} else {
+			uri = ""
+		}
+	}
+}
+
+// bodyReader bounds every read of a request body by the read timeout, so that a slow but alive
+// uploader is not cut off while a stalled one is.
+type bodyReader struct {
+	conn    net.Conn
+	r       io.Reader
+	timeout time.Duration
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.timeout > 0 {
+		b.conn.SetReadDeadline(time.Now().Add(b.timeout))
+	}
+
+	n, err := b.r.Read(p)
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return n, ErrReadTimeout
+	}
+	return n, err
+}
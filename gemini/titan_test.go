@@ -0,0 +1,69 @@
+package gemini
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestParseTitanRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		path   string
+		titan  Titan
+		status int // zero for a valid request
+	}{
+		{name: "Upload", raw: "titan://localhost/notes.gmi;size=5;mime=text/plain;token=secret\r\nhello",
+			path: "/notes.gmi", titan: Titan{Size: 5, Mime: "text/plain", Token: "secret"}},
+		{name: "DefaultMime", raw: "titan://localhost/notes.gmi;size=5\r\nhello",
+			path: "/notes.gmi", titan: Titan{Size: 5, Mime: TitanMimeType}},
+		{name: "Delete", raw: "titan://localhost/notes.gmi;size=0\r\n",
+			path: "/notes.gmi", titan: Titan{Size: 0, Mime: TitanMimeType}},
+		{name: "MissingParams", raw: "titan://localhost/notes.gmi\r\n", status: StatusBadRequest},
+		{name: "MissingSize", raw: "titan://localhost/notes.gmi;mime=text/plain\r\n", status: StatusBadRequest},
+		{name: "NegativeSize", raw: "titan://localhost/notes.gmi;size=-1\r\n", status: StatusBadRequest},
+		{name: "EmptyPath", raw: "titan://localhost;size=5\r\nhello", status: StatusBadRequest},
+		{name: "DotEscape", raw: "titan://localhost/../notes.gmi;size=5\r\nhello", status: StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseRequest(strings.NewReader(tt.raw + "trailing"))
+			if tt.status != 0 {
+				if gmierr, ok := err.(*GmiError); !ok || gmierr.Code != tt.status {
+					t.Fatalf("expected status %d, got %v", tt.status, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.URL.Path != tt.path || req.Titan == nil || *req.Titan != tt.titan {
+				t.Fatalf("unexpected request: %s %+v", req.URL.Path, req.Titan)
+			}
+
+			body, _ := ioutil.ReadAll(req.Body)
+			if want := tt.raw[strings.Index(tt.raw, Termination)+2:]; string(body) != want {
+				t.Errorf("expected body %q, got %q", want, body)
+			}
+		})
+	}
+}
+
+func TestRedactToken(t *testing.T) {
+	tests := map[string]string{
+		"gemini://localhost/notes.gmi":                       "gemini://localhost/notes.gmi",
+		"titan://localhost/notes.gmi;size=5;token=secret":    "titan://localhost/notes.gmi;size=5;token=REDACTED",
+		"titan://localhost/notes.gmi;token=secret;size=5":    "titan://localhost/notes.gmi;token=REDACTED;size=5",
+		"titan://localhost/notes.gmi;token=secret?q":         "titan://localhost/notes.gmi;token=REDACTED?q",
+		"titan://localhost/notes.gmi;token=a;size=5;token=b": "titan://localhost/notes.gmi;token=REDACTED;size=5;token=REDACTED",
+	}
+
+	for uri, want := range tests {
+		if got := redactToken(uri); got != want {
+			t.Errorf("%q: got %q, want %q", uri, got, want)
+		}
+	}
+}
@@ -3,12 +3,39 @@ package gemini
 import (
 	"crypto/rand"
 	"crypto/tls"
+	"strings"
 )
 
 func TLSConfig(sni string, cert tls.Certificate) *tls.Config {
+	config := baseTLSConfig()
+	config.ServerName = sni
+	config.Certificates = []tls.Certificate{cert}
+	return config
+}
+
+// HostsTLSConfig returns a TLS config that selects the certificate by the SNI hostname the client
+// asks for. Clients without SNI or asking for an unknown host are served the certificate of the
+// default host, unknown hosts can then be refused at the request level, see HostMux.
+func HostsTLSConfig(defaultHost string, certs map[string]tls.Certificate) *tls.Config {
+	hosts := make(map[string]*tls.Certificate, len(certs))
+	for host, cert := range certs {
+		cert := cert
+		hosts[strings.ToLower(host)] = &cert
+	}
+
+	config := baseTLSConfig()
+	config.ServerName = defaultHost
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if cert, ok := hosts[strings.ToLower(hello.ServerName)]; ok {
+			return cert, nil
+		}
+		return hosts[strings.ToLower(defaultHost)], nil
+	}
+	return config
+}
+
+func baseTLSConfig() *tls.Config {
 	return &tls.Config{
-		ServerName:               sni,
-		Certificates:             []tls.Certificate{cert},
 		Rand:                     rand.Reader,
 		MinVersion:               tls.VersionTLS12,
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
@@ -20,3 +47,23 @@ func TLSConfig(sni string, cert tls.Certificate) *tls.Config {
 		},
 	}
 }
+
+// certificateFor selects the certificate for the handshake from config. GetCertificate takes
+// precedence, otherwise the first certificate supporting the client hello is used with the first
+// certificate as fallback.
+func certificateFor(config *tls.Config, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if config.GetCertificate != nil {
+		return config.GetCertificate(hello)
+	}
+
+	if len(config.Certificates) == 0 {
+		return nil, ErrMissingCertificate
+	}
+
+	for i := range config.Certificates {
+		if err := hello.SupportsCertificate(&config.Certificates[i]); err == nil {
+			return &config.Certificates[i], nil
+		}
+	}
+	return &config.Certificates[0], nil
+}
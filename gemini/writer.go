@@ -0,0 +1,118 @@
+package gemini
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const writeBufferSize = 32 * 1024
+
+// Flusher is implemented by ResponseWriters that allow a handler to flush buffered data to the
+// client, e.g. when streaming a slowly generated document.
+type Flusher interface {
+	Flush() error
+}
+
+// writer is the connection backed ResponseWriter.
+type writer struct {
+	*ConnWriter
+	wroteHeader bool
+}
+
+func newWriter(conn net.Conn, timeout time.Duration) *writer {
+	return &writer{ConnWriter: NewConnWriter(conn, timeout)}
+}
+
+// WriteHeader writes the response header. It fails without writing if the header was written
+// before or if code or message violate the protocol, see ValidateHeader.
+func (w *writer) WriteHeader(code int, message string) (int, error) {
+	if w.wroteHeader {
+		return 0, ErrHeaderWritten
+	}
+	if err := ValidateHeader(code, message); err != nil {
+		return 0, err
+	}
+	w.wroteHeader = true
+
+	// <STATUS><SPACE><META><CR><LF>
+	if len(message) == 0 {
+		return w.Write([]byte(fmt.Sprintf("%d%s", code, Termination)))
+	}
+
+	return w.Write([]byte(fmt.Sprintf("%d %s%s", code, message, Termination)))
+}
+
+// ConnWriter buffers writes to a connection, each write to the connection is bound by the write
+// timeout, if set. It is the base of the servers ResponseWriter and of writers translating
+// responses to other protocols, see ConnServer.
+type ConnWriter struct {
+	conn    net.Conn
+	buf     *bufio.Writer
+	timeout time.Duration
+
+	// onWriteError is called when writing to the connection failed, the server cancels the
+	// request context with it.
+	onWriteError func()
+}
+
+// NewConnWriter returns a ConnWriter for conn, a timeout of zero means no timeout.
+func NewConnWriter(conn net.Conn, timeout time.Duration) *ConnWriter {
+	return &ConnWriter{
+		conn:    conn,
+		buf:     bufio.NewWriterSize(conn, writeBufferSize),
+		timeout: timeout,
+	}
+}
+
+func (w *ConnWriter) Write(body []byte) (int, error) {
+	w.setDeadline()
+	n, err := w.buf.Write(body)
+	w.failed(err)
+	return n, err
+}
+
+// ReadFrom streams from r to the client in chunks, refreshing the write deadline for every chunk
+// so that a slow but alive client is not cut off.
+func (w *ConnWriter) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	chunk := make([]byte, writeBufferSize)
+	for {
+		nr, rerr := r.Read(chunk)
+		if nr > 0 {
+			nw, werr := w.Write(chunk[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+		}
+
+		if rerr == io.EOF {
+			return n, nil
+		} else if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// Flush writes any buffered data to the client.
+func (w *ConnWriter) Flush() error {
+	w.setDeadline()
+	err := w.buf.Flush()
+	w.failed(err)
+	return err
+}
+
+func (w *ConnWriter) failed(err error) {
+	if err != nil && w.onWriteError != nil {
+		w.onWriteError()
+	}
+}
+
+func (w *ConnWriter) setDeadline() {
+	if w.timeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+}
@@ -0,0 +1,85 @@
+package geminitest
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// ResponseRecorder is a gemini.ResponseWriter that records the response for inspection in tests.
+type ResponseRecorder struct {
+	// Code is the status code of the first WriteHeader call, zero if none.
+	Code int
+
+	// Meta is the header addition of the first WriteHeader call, such as the mimetype.
+	Meta string
+
+	// Body holds the bytes written after the header.
+	Body *bytes.Buffer
+
+	// Flushed is set if the handler called Flush.
+	Flushed bool
+
+	wroteHeader bool
+}
+
+// NewRecorder returns an initialized ResponseRecorder.
+func NewRecorder() *ResponseRecorder {
+	return &ResponseRecorder{Body: new(bytes.Buffer)}
+}
+
+// WriteHeader records the status and meta. Like the server's writer it rejects invalid headers
+// and headers after the first one.
+func (rw *ResponseRecorder) WriteHeader(code int, message string) (int, error) {
+	if rw.wroteHeader {
+		return 0, gemini.ErrHeaderWritten
+	}
+	if err := gemini.ValidateHeader(code, message); err != nil {
+		return 0, err
+	}
+
+	rw.wroteHeader = true
+	rw.Code = code
+	rw.Meta = message
+	return 0, nil
+}
+
+// Write appends to Body.
+func (rw *ResponseRecorder) Write(body []byte) (int, error) {
+	if rw.Body == nil {
+		rw.Body = new(bytes.Buffer)
+	}
+	return rw.Body.Write(body)
+}
+
+// Flush implements gemini.Flusher.
+func (rw *ResponseRecorder) Flush() error {
+	rw.Flushed = true
+	return nil
+}
+
+// Header returns the recorded response header line without termination, e.g. "20 text/gemini".
+func (rw *ResponseRecorder) Header() string {
+	if rw.Meta == "" {
+		return fmt.Sprintf("%d", rw.Code)
+	}
+	return fmt.Sprintf("%d %s", rw.Code, rw.Meta)
+}
+
+// NewRequest returns a request for the absolute URL as a server would hand it to a handler. The
+// remote address is 192.0.2.1:1234 from the TEST-NET-1 block. NewRequest panics if rawurl does
+// not parse, it is meant for tests with fixed input.
+func NewRequest(rawurl string) *gemini.Request {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		panic("geminitest: invalid request url: " + err.Error())
+	}
+
+	return &gemini.Request{
+		URL:        u,
+		RemoteAddr: "192.0.2.1:1234",
+		RequestURI: rawurl,
+	}
+}
@@ -0,0 +1,127 @@
+// Package geminitest provides utilities for gemini handler and middleware testing.
+package geminitest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// shutdownTimeout bounds draining in-flight requests on Close.
+const shutdownTimeout = 5 * time.Second
+
+var (
+	certOnce sync.Once
+	cert     tls.Certificate
+	certErr  error
+)
+
+// certificate generates the throwaway server certificate once per test binary, RSA key
+// generation is too slow to repeat for every server.
+func certificate() (tls.Certificate, error) {
+	certOnce.Do(func() {
+		cert, certErr = gemini.GenX509KeyPair("127.0.0.1", 1)
+		if certErr == nil {
+			cert.Leaf, certErr = x509.ParseCertificate(cert.Certificate[0])
+		}
+	})
+	return cert, certErr
+}
+
+// Server is a gemini server listening on a random loopback port, for end to end tests.
+type Server struct {
+	// URL is the base URL of the form gemini://127.0.0.1:port without trailing slash.
+	URL      string
+	Listener net.Listener
+
+	// Config may be changed after NewUnstartedServer and before Start.
+	Config *gemini.Server
+
+	// Certificate is the servers self-signed certificate.
+	Certificate *x509.Certificate
+
+	done chan struct{}
+}
+
+// NewServer starts and returns a new Server serving handler. The caller should call Close when
+// finished, to shut it down.
+func NewServer(handler gemini.Handler) *Server {
+	s := NewUnstartedServer(handler)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new Server that listens but does not serve yet. Config may be
+// adjusted, e.g. to request client certificates, before calling Start.
+func NewUnstartedServer(handler gemini.Handler) *Server {
+	crt, err := certificate()
+	if err != nil {
+		panic(fmt.Sprintf("geminitest: generate certificate: %v", err))
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("geminitest: failed to listen on a port: %v", err))
+	}
+
+	return &Server{
+		Listener: l,
+		Config: &gemini.Server{
+			Handler:     handler,
+			TLSConfig:   gemini.TLSConfig("127.0.0.1", crt),
+			ReadTimeout: shutdownTimeout,
+		},
+		Certificate: crt.Leaf,
+	}
+}
+
+// Start starts serving requests.
+func (s *Server) Start() {
+	if s.done != nil {
+		panic("geminitest: server already started")
+	}
+
+	s.URL = "gemini://" + s.Listener.Addr().String()
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		if err := s.Config.Serve(s.Listener); err != nil && !errors.Is(err, gemini.ErrServerClosed) {
+			panic(fmt.Sprintf("geminitest: serve: %v", err))
+		}
+	}()
+}
+
+// Close shuts the server down and waits for in-flight requests to finish.
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	s.Config.Shutdown(ctx)
+	if s.done != nil {
+		<-s.done
+	} else {
+		s.Listener.Close()
+	}
+}
+
+// Client returns a client that trusts the servers certificate only.
+func (s *Server) Client() *gemini.Client {
+	known := gemini.NewKnownHosts()
+	known.Add(s.Listener.Addr().String(), gemini.KnownHost{
+		Fingerprint: gemini.Fingerprint(s.Certificate),
+		Expires:     s.Certificate.NotAfter,
+	})
+
+	return &gemini.Client{
+		KnownHosts:     known,
+		ConnectTimeout: shutdownTimeout,
+		ReadTimeout:    shutdownTimeout,
+	}
+}
@@ -0,0 +1,45 @@
+package geminitest
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+func TestServer(t *testing.T) {
+	ts := NewServer(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		w.WriteHeader(gemini.StatusSuccess, "text/gemini")
+		w.Write([]byte("# " + r.URL.Path))
+	}))
+	defer ts.Close()
+
+	rsp, err := ts.Client().Get(ts.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rsp.Status != gemini.StatusSuccess || rsp.Meta != "text/gemini" || string(body) != "# /hello" {
+		t.Errorf("got %d %q %q", rsp.Status, rsp.Meta, body)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder()
+	if _, err := rec.WriteHeader(gemini.StatusRedirectTemporary, "/other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.WriteHeader(gemini.StatusSuccess, "text/gemini"); err != gemini.ErrHeaderWritten {
+		t.Errorf("second header: got %v, want %v", err, gemini.ErrHeaderWritten)
+	}
+
+	if rec.Header() != "30 /other" {
+		t.Errorf("got header %q, want the first one", rec.Header())
+	}
+}
@@ -0,0 +1,163 @@
+// Package gopher serves gopher with gemini handlers. Selectors are mapped onto gemini requests,
+// gemtext responses are converted to gophermaps and other files are passed through, so that the
+// fileserver and the middlewares work unchanged.
+package gopher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// DefaultPort is the registered gopher port.
+const DefaultPort = 70
+
+// Server serves gopher over plain TCP.
+type Server struct {
+	// Addr is the TCP address to listen on, ":70" if empty.
+	Addr string
+
+	// Hostname is the host requests are mapped to and menu items point to. Port is the port menu
+	// items point to, the listening port if zero. Set both if the server is reached through NAT.
+	Hostname string
+	Port     int
+
+	// Handler is the gemini handler tree to serve, see the package doc.
+	Handler gemini.Handler
+
+	// ErrorHandler answers requests that fail before reaching Handler with a synthetic request
+	// with Err set, like gemini.Server.ErrorHandler. Defaults to gemini.HandleRequestError.
+	ErrorHandler gemini.Handler
+
+	// Logger receives leveled server events, logging is disabled if nil.
+	Logger gemini.Logger
+
+	// ReadTimeout bounds reading the selector, WriteTimeout every single write. HandlerTimeout
+	// cancels the request context. Zero means no timeout.
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	HandlerTimeout time.Duration
+
+	// MaxOpenConns limits the number of connections handled concurrently, zero means no limit.
+	// Clients beyond the limit wait in the listen backlog.
+	MaxOpenConns int
+
+	conns gemini.ConnServer
+}
+
+func (s *Server) log(level gemini.Level, msg string, keyvals ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Log(level, msg, keyvals...)
+	}
+}
+
+// ListenAndServe listens on s.Addr and then serves it.
+func (s *Server) ListenAndServe() error {
+	if s.conns.ShuttingDown() {
+		return gemini.ErrServerClosed
+	}
+
+	addr := s.Addr
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", DefaultPort)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("gopher server listen: %w", err)
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves them with the handler. Serve always returns a
+// non-nil error and closes l. After Shutdown the returned error is gemini.ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.conns.Logger = s.Logger
+	s.conns.ErrorHandler = s.ErrorHandler
+	s.conns.MaxOpenConns = s.MaxOpenConns
+	s.conns.HandlerTimeout = s.HandlerTimeout
+
+	err := s.conns.Serve(l, s.handleConnection)
+	if errors.Is(err, gemini.ErrServerClosed) {
+		return err
+	}
+	return fmt.Errorf("gopher server accept: %w", err)
+}
+
+// hostPort returns the host and port menu items point to.
+func (s *Server) hostPort(conn net.Conn) (string, int) {
+	host, port := s.Hostname, s.Port
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		if host == "" {
+			host = addr.IP.String()
+		}
+		if port == 0 {
+			port = addr.Port
+		}
+	}
+	return host, port
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	host, port := s.hostPort(conn)
+	w := newWriter(conn, s.WriteTimeout, host, port)
+	defer w.finish()
+
+	req, err := s.readRequest(conn, host)
+	if req != nil {
+		w.url = req.URL
+	}
+	if err != nil {
+		if errors.Is(err, gemini.ErrEmptyRequest) {
+			return
+		}
+		s.log(gemini.LevelDebug, "gopher request error", "error", err, "remote", conn.RemoteAddr())
+		s.conns.ServeError(w, conn, req, err)
+		return
+	}
+
+	// 'h' items to other servers, clients without URL support get a page with the link.
+	if strings.HasPrefix(req.RequestURI, urlPrefix) {
+		target := strings.SplitN(strings.TrimPrefix(req.RequestURI, urlPrefix), "\t", 2)[0]
+		fmt.Fprintf(w.conn, urlPage, html.EscapeString(target), html.EscapeString(target), html.EscapeString(target))
+		return
+	}
+
+	ctx, cancel := s.conns.RequestContext()
+	defer cancel()
+
+	req = req.WithContext(ctx)
+	req.RemoteAddr = conn.RemoteAddr().String()
+
+	s.conns.ServeRequest(w, req, s.Handler)
+}
+
+// urlPage redirects clients following an 'h' item with a "URL:" selector.
+const urlPage = `<!DOCTYPE html>
+<html><head><meta http-equiv="refresh" content="0;url=%s"><title>Redirect</title></head>
+<body><p>You are following a link to <a href="%s">%s</a>.</p></body></html>
+`
+
+// readRequest reads the selector line within the read timeout.
+func (s *Server) readRequest(conn net.Conn, host string) (*gemini.Request, error) {
+	if s.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	return ParseRequest(conn, host)
+}
+
+// Shutdown stops accepting new connections, cancels the request contexts and waits for in-flight
+// requests to finish. If the context deadline passes first, the remaining connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.conns.Shutdown(ctx); err != nil {
+		return fmt.Errorf("gopher: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package gopher
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+func TestConvert(t *testing.T) {
+	gemtext := strings.Join([]string{
+		"# Title",
+		"",
+		strings.Repeat("word ", 20),
+		"=> /docs/ Docs",
+		"=> notes.txt",
+		"=> /search?gemini%20space Search",
+		"=> /calc?1+1 Calc",
+		"=> image.gif Image",
+		"=> https://example.org/ Web",
+		"=> gopher://example.org:7070/0/about.txt About",
+		"=> gopher://example.com/0/about.txt Local",
+		"* " + strings.Repeat("item ", 15),
+		"```",
+		"  keep\tspacing",
+		"```",
+		"no trailing newline",
+	}, "\n")
+
+	want := strings.Join([]string{
+		"i# Title\t\tnull.host\t1",
+		"i\t\tnull.host\t1",
+		"i" + strings.TrimSpace(strings.Repeat("word ", 14)) + "\t\tnull.host\t1",
+		"i" + strings.TrimSpace(strings.Repeat("word ", 6)) + "\t\tnull.host\t1",
+		"1Docs\t/docs/\texample.com\t70",
+		"0notes.txt\t/blog/notes.txt\texample.com\t70",
+		"1Search\t/search\tgemini space\texample.com\t70",
+		"1Calc\t/calc\t1+1\texample.com\t70",
+		"gImage\t/blog/image.gif\texample.com\t70",
+		"hWeb\tURL:https://example.org/\texample.com\t70",
+		"0About\t/about.txt\texample.org\t7070",
+		"0Local\t/about.txt\texample.com\t70",
+		"i* " + strings.TrimSpace(strings.Repeat("item ", 13)) + "\t\tnull.host\t1",
+		"i  item item\t\tnull.host\t1",
+		"i  keep    spacing\t\tnull.host\t1",
+		"ino trailing newline\t\tnull.host\t1",
+		".",
+		"",
+	}, "\r\n")
+
+	var buf bytes.Buffer
+	base, _ := url.Parse("gopher://example.com/blog/index.gmi")
+	c := &converter{menu: NewMenu(&buf, "example.com", 70), base: base}
+
+	// split writes must not break lines
+	for _, chunk := range []string{gemtext[:7], gemtext[7:50], gemtext[50:]} {
+		if _, err := c.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestItemType(t *testing.T) {
+	tests := map[string]byte{
+		"/":           TypeMenu,
+		"/docs":       TypeMenu,
+		"/index.gmi":  TypeMenu,
+		"/notes.txt":  TypeText,
+		"/photo.jpg":  TypeImage,
+		"/anim.gif":   TypeGIF,
+		"/page.html":  TypeHTML,
+		"/paper.pdf":  TypeDocument,
+		"/archive.gz": TypeBinary,
+	}
+
+	for p, want := range tests {
+		if got := ItemType(p); got != want {
+			t.Errorf("%s: got %c, want %c", p, got, want)
+		}
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		raw    string
+		url    string
+		input  string
+		status int // zero for a valid request
+	}{
+		{raw: "\r\n", url: "gopher://localhost/"},
+		{raw: "/docs/\r\n", url: "gopher://localhost/docs/"},
+		{raw: "notes.txt\n", url: "gopher://localhost/notes.txt"},
+		{raw: "/search\tgemini space\r\n", url: "gopher://localhost/search?gemini%20space", input: "gemini space"},
+		{raw: "/docs\t+\r\n", url: "gopher://localhost/docs"},
+		{raw: "/../etc/passwd\r\n", status: gemini.StatusBadRequest},
+		{raw: "", status: gemini.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req, err := ParseRequest(strings.NewReader(tt.raw), "localhost")
+		if tt.status != 0 {
+			if gmierr, ok := err.(*gemini.GmiError); !ok || gmierr.Code != tt.status {
+				t.Errorf("%q: expected status %d, got %v", tt.raw, tt.status, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.raw, err)
+			continue
+		}
+		if input, _ := req.Input(); req.URL.String() != tt.url || input != tt.input {
+			t.Errorf("%q: got %s %q, want %s %q", tt.raw, req.URL, input, tt.url, tt.input)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		Hostname: "localhost",
+		Port:     70,
+		Handler: gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+			switch r.URL.Path {
+			case "/":
+				gemini.Success(w, "")
+				w.Write([]byte("hello\n=> /file.bin File\n"))
+			case "/file.bin":
+				gemini.Success(w, "application/octet-stream")
+				w.Write([]byte{0, 1, 2})
+			case "/search":
+				gemini.Input(w, "query?")
+			default:
+				gemini.NotFound(w)
+			}
+		}),
+	}
+	go s.Serve(l)
+	defer s.Shutdown(context.Background())
+
+	tests := []struct {
+		request string
+		want    string
+	}{
+		{"\r\n", "ihello\t\tnull.host\t1\r\n9File\t/file.bin\tlocalhost\t70\r\n.\r\n"},
+		{"/file.bin\r\n", "\x00\x01\x02"},
+		{"/search\r\n", "7query?\t/search\tlocalhost\t70\r\n.\r\n"},
+		{"/missing\r\n", "3not found\t\tnull.host\t1\r\n.\r\n"},
+	}
+
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.Write([]byte(tt.request))
+		rsp, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rsp) != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.request, rsp, tt.want)
+		}
+	}
+}
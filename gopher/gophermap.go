@@ -0,0 +1,285 @@
+package gopher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// LineWidth is the column text lines are reflowed to.
+const LineWidth = 70
+
+// Item types used in converted gophermaps.
+const (
+	TypeText     = '0'
+	TypeMenu     = '1'
+	TypeError    = '3'
+	TypeSearch   = '7'
+	TypeBinary   = '9'
+	TypeGIF      = 'g'
+	TypeImage    = 'I'
+	TypeSound    = 's'
+	TypeHTML     = 'h'
+	TypeInfo     = 'i'
+	TypeDocument = 'd'
+)
+
+// Info lines and errors point nowhere by convention.
+const (
+	infoHost = "null.host"
+	infoPort = 1
+)
+
+// urlPrefix marks selectors of 'h' items linking to URLs on other servers.
+const urlPrefix = "URL:"
+
+// Menu writes gophermap items for the host and port served.
+type Menu struct {
+	w    io.Writer
+	host string
+	port int
+}
+
+// NewMenu returns a menu writing to w, local items point to host and port.
+func NewMenu(w io.Writer, host string, port int) *Menu {
+	return &Menu{w: w, host: host, port: port}
+}
+
+// Item writes a menu item. Tabs and line breaks in display and selector are replaced, they
+// would break the map.
+func (m *Menu) Item(itemType byte, display, selector, host string, port int) error {
+	return m.item(itemType, display, sanitize(selector), host, port)
+}
+
+// item writes a menu item with a selector that is already sanitized.
+func (m *Menu) item(itemType byte, display, selector, host string, port int) error {
+	_, err := fmt.Fprintf(m.w, "%c%s\t%s\t%s\t%d\r\n", itemType, sanitize(display), selector, host, port)
+	return err
+}
+
+// Info writes an informational text line.
+func (m *Menu) Info(text string) error {
+	return m.Item(TypeInfo, text, "", infoHost, infoPort)
+}
+
+// Error writes an error item.
+func (m *Menu) Error(text string) error {
+	return m.Item(TypeError, text, "", infoHost, infoPort)
+}
+
+// Link writes a menu item for the gemtext link target, resolved against base. Targets on the
+// served host become items of the type matching their extension, gopher URLs keep their host and
+// type and other URLs become 'h' items with a "URL:" selector.
+func (m *Menu) Link(base *url.URL, target, label string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return m.Info(label)
+	}
+
+	// relative targets resolve to gopher URLs too, only absolute ones carry the item type
+	gopherURL := u.Scheme == "gopher"
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if label == "" {
+		label = target
+	}
+
+	local := base != nil && strings.EqualFold(u.Hostname(), base.Hostname())
+	switch {
+	case gopherURL:
+		return m.gopherLink(u, label)
+	case local && (u.Scheme == base.Scheme || u.Scheme == "gemini"):
+		// the query is sent as plain search string, separated by a tab that must survive
+		// sanitizing. ParseRequest escapes it again. '+' is no space, see Request.Input.
+		selector := sanitize(u.Path)
+		if u.RawQuery != "" {
+			query, err := url.PathUnescape(u.RawQuery)
+			if err != nil {
+				query = u.RawQuery
+			}
+			selector += "\t" + sanitize(query)
+		}
+		return m.item(ItemType(u.Path), label, selector, m.host, m.port)
+	default:
+		return m.Item(TypeHTML, label, urlPrefix+u.String(), m.host, m.port)
+	}
+}
+
+// gopherLink writes an item for a gopher URL, gopher://host[:port]/<type><selector>.
+func (m *Menu) gopherLink(u *url.URL, label string) error {
+	port := 70
+	if p, err := strconv.Atoi(u.Port()); err == nil {
+		port = p
+	}
+
+	itemType, selector := byte(TypeMenu), ""
+	if p := strings.TrimPrefix(u.Path, "/"); p != "" {
+		itemType, selector = p[0], p[1:]
+	}
+	return m.Item(itemType, label, selector, u.Hostname(), port)
+}
+
+// End terminates the menu with the lone dot line.
+func (m *Menu) End() error {
+	_, err := io.WriteString(m.w, ".\r\n")
+	return err
+}
+
+// ItemType returns the gopher item type for a path by its extension. Directories and gemtext
+// are served as converted menus.
+func ItemType(p string) byte {
+	ext := path.Ext(p)
+	if strings.HasSuffix(p, "/") || ext == "" || ext == ".gmi" {
+		return TypeMenu
+	}
+
+	mimeType := mime.TypeByExtension(ext)
+	mediaType := strings.SplitN(mimeType, ";", 2)[0]
+	switch {
+	case mediaType == "text/html":
+		return TypeHTML
+	case mediaType == "image/gif":
+		return TypeGIF
+	case mediaType == "application/pdf":
+		return TypeDocument
+	case strings.HasPrefix(mediaType, "text/"):
+		return TypeText
+	case strings.HasPrefix(mediaType, "image/"):
+		return TypeImage
+	case strings.HasPrefix(mediaType, "audio/"):
+		return TypeSound
+	default:
+		return TypeBinary
+	}
+}
+
+// converter converts gemtext into a gophermap line by line as it is written.
+type converter struct {
+	menu         *Menu
+	base         *url.URL
+	partial      []byte
+	preformatted bool
+}
+
+func (c *converter) Write(p []byte) (int, error) {
+	c.partial = append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(c.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		line := strings.TrimSuffix(string(c.partial[:i]), "\r")
+		c.partial = c.partial[i+1:]
+		if err := c.line(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Close converts a trailing line without line feed and terminates the menu.
+func (c *converter) Close() error {
+	if len(c.partial) > 0 {
+		if err := c.line(string(c.partial)); err != nil {
+			return err
+		}
+		c.partial = nil
+	}
+	return c.menu.End()
+}
+
+func (c *converter) line(line string) error {
+	if strings.HasPrefix(line, "```") {
+		c.preformatted = !c.preformatted
+		return nil
+	} else if c.preformatted {
+		return c.menu.Info(line)
+	}
+
+	switch {
+	case strings.HasPrefix(line, "=>"):
+		link := strings.TrimSpace(line[2:])
+		fields := strings.Fields(link)
+		if len(fields) == 0 {
+			return nil
+		}
+		return c.menu.Link(c.base, fields[0], strings.TrimSpace(link[len(fields[0]):]))
+	case strings.HasPrefix(line, "* "):
+		return c.reflow(line[2:], "* ", "  ")
+	case strings.HasPrefix(line, ">"):
+		return c.reflow(strings.TrimSpace(line[1:]), "> ", "> ")
+	default:
+		return c.reflow(line, "", "")
+	}
+}
+
+// reflow writes text as info lines wrapped at LineWidth columns. The first line is prefixed with
+// first, continuation lines with indent.
+func (c *converter) reflow(text, first, indent string) error {
+	lines := wrap(text, LineWidth-len(first))
+	if len(lines) == 0 {
+		return c.menu.Info(strings.TrimRight(first, " "))
+	}
+
+	for i, l := range lines {
+		prefix := indent
+		if i == 0 {
+			prefix = first
+		}
+		if err := c.menu.Info(prefix + l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrap breaks text at spaces into lines of at most width runes, longer words are split.
+func wrap(text string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		for utf8.RuneCountInString(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			r := []rune(word)
+			lines = append(lines, string(r[:width]))
+			word = string(r[width:])
+		}
+
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// sanitize replaces characters with meaning in gophermaps.
+func sanitize(s string) string {
+	return strings.NewReplacer("\t", "    ", "\r", "", "\n", " ").Replace(s)
+}
+
+// isGemtext reports whether the mimetype of a success response is gemtext.
+func isGemtext(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	return err == nil && mediaType == strings.SplitN(gemini.MimeType, ";", 2)[0]
+}
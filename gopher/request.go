@@ -0,0 +1,53 @@
+package gopher
+
+import (
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// ParseRequest reads a gopher selector line from r and maps it onto a gemini request for host:
+//
+//	<selector>[<TAB><search>]<CR><LF>
+//
+// The selector becomes the path, an empty selector the root. The search string of type 7 items
+// becomes the percent-encoded query, so that handlers read it with Request.Input. Gopher+
+// fields are ignored. "URL:" selectors of 'h' items are left to the server. Errors are of type
+// *gemini.GmiError with a gemini status.
+func ParseRequest(r io.Reader, host string) (*gemini.Request, error) {
+	// a bare line feed is accepted, some clients send it.
+	line, err := gemini.ReadLine(r, gemini.URLMaxBytes, false)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &gemini.Request{RequestURI: line}
+	if !utf8.ValidString(line) {
+		return req, gemini.Error(gemini.StatusBadRequest, gemini.ErrInvalidUtf8)
+	}
+
+	fields := strings.Split(line, "\t")
+	selector := fields[0]
+	if strings.HasPrefix(selector, urlPrefix) {
+		// answered by the server with a link page, the URL is no path.
+		req.URL = &url.URL{Scheme: "gopher", Host: host, Path: "/"}
+		return req, nil
+	} else if !strings.HasPrefix(selector, "/") {
+		selector = "/" + selector
+	}
+
+	u := &url.URL{Scheme: "gopher", Host: host, Path: selector}
+	if cleaned := path.Clean(u.Path); cleaned != u.Path && cleaned != strings.TrimRight(u.Path, "/") {
+		return req, gemini.Error(gemini.StatusBadRequest, gemini.ErrInvalidPath)
+	}
+	if len(fields) > 1 && fields[1] != "" && fields[1] != "+" {
+		u.RawQuery = url.PathEscape(fields[1])
+	}
+	req.URL = u
+
+	return req, nil
+}
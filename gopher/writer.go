@@ -0,0 +1,89 @@
+package gopher
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// writer translates gemini responses to gopher. Gemtext is converted to a gophermap, other
+// success responses are passed through and failures, prompts and redirects are answered with a
+// single item menu. Writes are buffered like in the gemini server.
+type writer struct {
+	conn        *gemini.ConnWriter
+	menu        *Menu
+	url         *url.URL
+	body        io.Writer
+	conv        *converter
+	wroteHeader bool
+}
+
+func newWriter(conn net.Conn, timeout time.Duration, host string, port int) *writer {
+	cw := gemini.NewConnWriter(conn, timeout)
+	return &writer{conn: cw, menu: NewMenu(cw, host, port)}
+}
+
+func (w *writer) WriteHeader(code int, message string) (int, error) {
+	if w.wroteHeader {
+		return 0, gemini.ErrHeaderWritten
+	}
+	if err := gemini.ValidateHeader(code, message); err != nil {
+		return 0, err
+	}
+	w.wroteHeader = true
+
+	switch code / 10 {
+	case 1:
+		selector := ""
+		if w.url != nil {
+			selector = w.url.Path
+		}
+		return 0, w.end(w.menu.Item(TypeSearch, message, selector, w.menu.host, w.menu.port))
+	case 2:
+		if isGemtext(message) {
+			w.conv = &converter{menu: w.menu, base: w.url}
+			w.body = w.conv
+		} else {
+			w.body = w.conn
+		}
+		return 0, nil
+	case 3:
+		return 0, w.end(w.menu.Link(w.url, message, "moved to "+message))
+	default:
+		return 0, w.end(w.menu.Error(message))
+	}
+}
+
+func (w *writer) end(err error) error {
+	if err != nil {
+		return err
+	}
+	return w.menu.End()
+}
+
+// Write writes the body of success responses, it is discarded for other responses.
+func (w *writer) Write(body []byte) (int, error) {
+	if w.body == nil {
+		return len(body), nil
+	}
+
+	return w.body.Write(body)
+}
+
+// Flush writes any buffered data to the client.
+func (w *writer) Flush() error {
+	return w.conn.Flush()
+}
+
+// finish terminates a converted gophermap and flushes.
+func (w *writer) finish() error {
+	if w.conv != nil {
+		if err := w.conv.Close(); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd socket activation.
+const listenFdsStart = 3
+
+// listen returns the listener handed over by a previous gmifs process or inherited through
+// systemd socket activation if there is one, or listens on addr otherwise.
+func listen(addr string) (net.Listener, error) {
+	l, err := upgradeListener()
+	if err != nil {
+		return nil, err
+	} else if l != nil {
+		log.Printf("using listener on %v handed over by previous process\n", l.Addr())
+		return l, nil
+	}
+
+	l, err = systemdListener()
+	if err != nil {
+		return nil, err
+	} else if l != nil {
+		log.Printf("using socket activated listener on %v, ignoring -addr\n", l.Addr())
+		return l, nil
+	}
+
+	network, address, _ := gemini.ParseAddr(addr)
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+
+	l, err = net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	return l, nil
+}
+
+// listenTCP returns the listener handed over by a previous gmifs process in the environment
+// variable env or listens on the TCP address addr. It is used for the spartan and gopher
+// listeners next to the gemini one.
+func listenTCP(env, addr string) (net.Listener, error) {
+	l, err := inheritedListener(env)
+	if err != nil {
+		return nil, err
+	} else if l != nil {
+		log.Printf("using listener on %v handed over by previous process\n", l.Addr())
+		return l, nil
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	return l, nil
+}
+
+// removeStaleSocket removes a unix socket left behind by a process that did not exit cleanly.
+// Sockets that still accept connections are left alone, listen then fails.
+func removeStaleSocket(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// systemdListener returns the first listener passed via LISTEN_FDS if LISTEN_PID matches this
+// process. It returns nil without error if the process was not socket activated.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+
+	// don't pass them on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if fds > 1 {
+		log.Printf("socket activation passed %d sockets, using the first\n", fds)
+	}
+
+	f := os.NewFile(uintptr(listenFdsStart), "LISTEN_FD_"+strconv.Itoa(listenFdsStart))
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: %w", err)
+	}
+	return l, nil
+}
+
+// parseNetworks parses a comma separated list of IPs and CIDRs.
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %w", err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -7,21 +7,30 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/n0x1m/gmifs/fileserver"
 	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/gopher"
 	"github.com/n0x1m/gmifs/middleware"
+	"github.com/n0x1m/gmifs/spartan"
 )
 
 const (
 	defaultAddress          = ":1965"
 	defaultMaxConns         = 128
+	defaultMaxConnsPerIP    = 0
+	defaultOverflow         = "queue"
+	defaultQueueSize        = 0
+	defaultQueueTimeout     = 0
 	defaultTimeout          = 5
+	defaultHandlerTimeout   = 0
 	defaultCacheObjects     = 0
 	defaultRootPath         = "public"
 	defaultHost             = "localhost"
@@ -30,17 +39,39 @@ const (
 	defaultLogsDir          = ""
 	defaultDebugMode        = false
 	defaultAutoIndex        = false
+	defaultClientCerts      = false
+	defaultProxyProtocol    = false
+	defaultTrustedProxies   = ""
 	defaultAutoCertValidity = 1
+	defaultTitan            = false
+	defaultTitanMaxSize     = 1 << 20
+	defaultTitanMimeTypes   = "text/gemini,text/plain"
+	defaultTitanTokens      = ""
+	defaultTitanCerts       = ""
+	defaultSpartanAddress   = ""
+	defaultGopherAddress    = ""
+	defaultGopherPort       = 0
+	defaultProxyHosts       = ""
+	defaultProxyMaxSize     = gemini.DefaultProxyMaxBytes
+	defaultProxyTimeout     = 30
 )
 
 func main() {
-	var addr, root, crt, key, host, logs string
-	var maxconns, timeout, cache, autocertvalidity int
-	var debug, autoindex bool
+	var addr, root, crt, key, host, logs, trustedproxies, overflow string
+	var titanmimes, titantokens, titancerts, spartanaddr, gopheraddr, proxyhosts string
+	var maxconns, maxconnsperip, queuesize, queuetimeout, timeout, handlertimeout, cache, autocertvalidity, gopherport, proxytimeout int
+	var titanmaxsize, proxymaxsize int64
+	var debug, autoindex, clientcerts, proxyprotocol, titan bool
+	var vhosts vhostFlag
 
-	flag.StringVar(&addr, "addr", defaultAddress, "address to listen on, e.g. 127.0.0.1:1965")
-	flag.IntVar(&maxconns, "max-conns", defaultMaxConns, "maximum number of concurrently open connections")
-	flag.IntVar(&timeout, "timeout", defaultTimeout, "connection timeout in seconds")
+	flag.StringVar(&addr, "addr", defaultAddress, "address to listen on, e.g. 127.0.0.1:1965. Plaintext without TLS with unix:/path or tcp:127.0.0.1:1966")
+	flag.IntVar(&maxconns, "max-conns", defaultMaxConns, "maximum number of concurrently open connections, applies to each of the gemini, spartan and gopher servers")
+	flag.IntVar(&maxconnsperip, "max-conns-per-ip", defaultMaxConnsPerIP, "maximum number of concurrent connections per client address. Disabled when zero and in plaintext mode without -proxy-protocol.")
+	flag.StringVar(&overflow, "overflow", defaultOverflow, "policy beyond max-conns: queue, slowdown (44) or unavailable (41)")
+	flag.IntVar(&queuesize, "queue-size", defaultQueueSize, "connections to queue beyond max-conns with the queue policy. Defaults to max-conns when zero.")
+	flag.IntVar(&queuetimeout, "queue-timeout", defaultQueueTimeout, "seconds a queued connection waits before 41. Defaults to timeout when zero.")
+	flag.IntVar(&timeout, "timeout", defaultTimeout, "connection read and write timeout in seconds")
+	flag.IntVar(&handlertimeout, "handler-timeout", defaultHandlerTimeout, "request handler deadline in seconds. Disabled when zero.")
 	flag.IntVar(&cache, "cache", defaultCacheObjects, "simple fifo document cache for n items. Disabled when zero.")
 	flag.StringVar(&root, "root", defaultRootPath, "server root directory to serve from")
 	flag.StringVar(&host, "host", defaultHost, "hostname for sni and x509 CN when using temporary self-signed certs")
@@ -48,8 +79,23 @@ func main() {
 	flag.StringVar(&key, "key", defaultKeyPath, "TLS private key")
 	flag.IntVar(&autocertvalidity, "autocertvalidity", defaultAutoCertValidity, "valid days when using a gmifs provisioned certificate")
 	flag.StringVar(&logs, "logs", defaultLogsDir, "enables file based logging and specifies the directory")
-	flag.BoolVar(&debug, "debug", defaultDebugMode, "enable verbose logging of the gemini server")
+	flag.BoolVar(&debug, "debug", defaultDebugMode, "log server events from debug level instead of info, to debug.log with -logs")
 	flag.BoolVar(&autoindex, "autoindex", defaultAutoIndex, "enables auto indexing, directory listings")
+	flag.Var(&vhosts, "vhost", "additional virtual host as hostname,root[,cert,key], may be repeated")
+	flag.BoolVar(&clientcerts, "clientcerts", defaultClientCerts, "request client certificates, self-signed certificates are accepted")
+	flag.BoolVar(&proxyprotocol, "proxy-protocol", defaultProxyProtocol, "accept PROXY protocol v1/v2 headers from trusted proxies")
+	flag.StringVar(&trustedproxies, "trusted-proxies", defaultTrustedProxies, "comma separated IPs or CIDRs allowed to send PROXY protocol headers")
+	flag.BoolVar(&titan, "titan", defaultTitan, "accept titan uploads into the server root, authorized by token or client certificate")
+	flag.Int64Var(&titanmaxsize, "titan-max-size", defaultTitanMaxSize, "maximum titan upload size in bytes. Unlimited when zero.")
+	flag.StringVar(&titanmimes, "titan-mimes", defaultTitanMimeTypes, "comma separated mime types allowed for titan uploads. Any served type when empty.")
+	flag.StringVar(&titantokens, "titan-tokens", defaultTitanTokens, "comma separated tokens authorizing titan uploads")
+	flag.StringVar(&titancerts, "titan-certs", defaultTitanCerts, "comma separated SHA-256 client certificate fingerprints authorizing titan uploads")
+	flag.StringVar(&spartanaddr, "spartan-addr", defaultSpartanAddress, "additionally serve spartan on this address, e.g. :300. Disabled when empty.")
+	flag.StringVar(&gopheraddr, "gopher-addr", defaultGopherAddress, "additionally serve gopher on this address, e.g. :70. Disabled when empty.")
+	flag.IntVar(&gopherport, "gopher-port", defaultGopherPort, "port gopher menu items point to, e.g. behind NAT. Defaults to the listening port when zero.")
+	flag.StringVar(&proxyhosts, "proxy-hosts", defaultProxyHosts, "comma separated gemini hosts as host:port to forward requests to, a bare host is port 1965. Disabled when empty.")
+	flag.Int64Var(&proxymaxsize, "proxy-max-size", defaultProxyMaxSize, "maximum size of proxied responses in bytes")
+	flag.IntVar(&proxytimeout, "proxy-timeout", defaultProxyTimeout, "upstream timeout of proxied requests in seconds")
 	flag.Parse()
 
 	var err error
@@ -74,65 +120,315 @@ func main() {
 		os.Exit(1)
 	}
 
-	logprefix := host + " "
+	// the default host is served to clients without or with an unknown SNI hostname.
+	hosts := append([]vhost{{host: host, root: root, crt: crt, key: key}}, vhosts...)
 
-	mux := gemini.NewMux()
-	mux.Use(middleware.Logger(flogger, logprefix))
-	mux.Use(middleware.Cache(cache))
-	mux.Handle(gemini.HandlerFunc(fileserver.Serve(root, autoindex)))
+	var upload *fileserver.UploadConfig
+	if titan {
+		upload = &fileserver.UploadConfig{
+			MaxSize:      titanmaxsize,
+			MimeTypes:    splitList(titanmimes),
+			Tokens:       splitList(titantokens),
+			Fingerprints: splitList(titancerts),
+		}
+		if len(upload.Tokens) == 0 && len(upload.Fingerprints) == 0 {
+			log.Fatal("titan uploads require titan-tokens or titan-certs")
+		}
+	}
+
+	// a single host refuses foreign hosts in the server, several in the host mux.
+	var handler gemini.Handler
+	var hostname string
+	if len(hosts) == 1 {
+		hostname = host
+		handler = setupHandler(host, root, flogger, cache, autoindex, upload)
+	} else {
+		hostmux := gemini.NewHostMux()
+		for _, vh := range hosts {
+			hostmux.Handle(vh.host, setupHandler(vh.host, vh.root, flogger, cache, autoindex, upload))
+		}
+		handler = hostmux
+	}
+
+	var proxy *gemini.Proxy
+	var proxyhandler gemini.Handler
+	if upstreams := splitList(proxyhosts); len(upstreams) > 0 {
+		for _, upstream := range upstreams {
+			for _, vh := range hosts {
+				if strings.EqualFold(strings.SplitN(upstream, ":", 2)[0], vh.host) {
+					log.Fatalf("proxy host %s is served by gmifs", upstream)
+				}
+			}
+		}
+
+		proxy = &gemini.Proxy{
+			Hosts:    upstreams,
+			MaxBytes: proxymaxsize,
+			Timeout:  time.Duration(proxytimeout) * time.Second,
+		}
+		proxyhandler = setupProxyHandler(proxy, flogger)
+	}
+
+	overflowpolicy, err := gemini.ParseOverflowPolicy(overflow)
+	if err != nil {
+		log.Fatalf("%v: %s", err, overflow)
+	}
+
+	proxies, err := parseNetworks(trustedproxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// load certificates before taking over connections so that a broken key pair fails early,
+	// unless TLS is terminated in front of gmifs.
+	var tlsconfig *tls.Config
+	var tlsloader func() (*tls.Config, error)
+	_, _, plaintext := gemini.ParseAddr(addr)
+	if !plaintext {
+		tlsloader = setupCertificates(hosts, autocertvalidity)
+		tlsconfig, err = tlsloader()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	server := &gemini.Server{
-		Addr:            addr,
-		Hostname:        host,
-		TLSConfigLoader: setupCertificate(crt, key, host, autocertvalidity),
-		Handler:         mux,
-		MaxOpenConns:    maxconns,
-		ReadTimeout:     time.Duration(timeout) * time.Second,
-		Logger:          dlogger,
+		Addr:               addr,
+		Hostname:           hostname,
+		Plaintext:          plaintext,
+		TLSConfig:          tlsconfig,
+		TLSConfigLoader:    tlsloader,
+		RequestClientCerts: clientcerts || titan,
+		Titan:              titan,
+		Proxy:              proxy,
+		ProxyHandler:       proxyhandler,
+		ProxyProtocol:      proxyprotocol,
+		TrustedProxies:     proxies,
+		Handler:            handler,
+		ErrorHandler:       setupErrorHandler(host, flogger),
+		MaxOpenConns:       maxconns,
+		MaxConnsPerIP:      maxconnsperip,
+		OverflowPolicy:     overflowpolicy,
+		QueueSize:          queuesize,
+		QueueTimeout:       time.Duration(queuetimeout) * time.Second,
+		ReadTimeout:        time.Duration(timeout) * time.Second,
+		WriteTimeout:       time.Duration(timeout) * time.Second,
+		HandlerTimeout:     time.Duration(handlertimeout) * time.Second,
+	}
+
+	// server events from info level, e.g. timeouts and a bad certificate renewal, always reach
+	// stderr. -debug lowers the level and logs to the debug logger instead.
+	slogger, level := log.New(os.Stderr, "", log.LUTC|log.Ldate|log.Ltime), gemini.LevelInfo
+	if debug {
+		slogger, level = dlogger, gemini.LevelDebug
+	}
+	server.Logger = gemini.StdLogger(slogger, level)
+
+	listener, err := listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// spartan serves the same handler tree in plain text.
+	var spartanserver *spartan.Server
+	var spartanlistener net.Listener
+	if spartanaddr != "" {
+		spartanlistener, err = listenTCP(envSpartanFd, spartanaddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		spartanserver = &spartan.Server{
+			Hostname:       hostname,
+			Handler:        handler,
+			ErrorHandler:   setupErrorHandler(host, flogger),
+			Logger:         server.Logger,
+			ReadTimeout:    time.Duration(timeout) * time.Second,
+			WriteTimeout:   time.Duration(timeout) * time.Second,
+			HandlerTimeout: time.Duration(handlertimeout) * time.Second,
+			MaxOpenConns:   maxconns,
+		}
+	}
+
+	// gopher serves the default host, gemtext is converted to gophermaps.
+	var gopherserver *gopher.Server
+	var gopherlistener net.Listener
+	if gopheraddr != "" {
+		gopherlistener, err = listenTCP(envGopherFd, gopheraddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		gopherserver = &gopher.Server{
+			Hostname:       host,
+			Port:           gopherport,
+			Handler:        handler,
+			ErrorHandler:   setupErrorHandler(host, flogger),
+			Logger:         server.Logger,
+			ReadTimeout:    time.Duration(timeout) * time.Second,
+			WriteTimeout:   time.Duration(timeout) * time.Second,
+			HandlerTimeout: time.Duration(handlertimeout) * time.Second,
+			MaxOpenConns:   maxconns,
+		}
 	}
 
 	confirm := make(chan struct{}, 1)
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, gemini.ErrServerClosed) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, gemini.ErrServerClosed) {
 			log.Fatalf("ListenAndServe terminated unexpectedly: %v", err)
 		}
 
 		close(confirm)
 	}()
 
+	if spartanserver != nil {
+		go func() {
+			if err := spartanserver.Serve(spartanlistener); err != nil && !errors.Is(err, gemini.ErrServerClosed) {
+				log.Fatalf("spartan server terminated unexpectedly: %v", err)
+			}
+		}()
+	}
+
+	if gopherserver != nil {
+		go func() {
+			if err := gopherserver.Serve(gopherlistener); err != nil && !errors.Is(err, gemini.ErrServerClosed) {
+				log.Fatalf("gopher server terminated unexpectedly: %v", err)
+			}
+		}()
+	}
+
+	if err := notifyReady(); err != nil {
+		log.Print(err)
+	}
+
+	// SIGUSR2 hands the listener to a freshly started binary and drains this process.
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	<-stop
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR2)
+	for sig := range stop {
+		if sig == syscall.SIGUSR2 {
+			extra := map[string]net.Listener{envSpartanFd: spartanlistener, envGopherFd: gopherlistener}
+			if err := upgrade(listener, extra); err != nil {
+				log.Printf("%v, continuing to serve", err)
+				continue
+			}
+			log.Printf("upgraded process took over the listener, draining")
+		}
+		break
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	spartandone := make(chan struct{})
+	go func() {
+		if spartanserver != nil {
+			if err := spartanserver.Shutdown(ctx); err != nil {
+				log.Printf("spartan server shutdown with error: %v", err)
+			}
+		}
+		close(spartandone)
+	}()
+	gopherdone := make(chan struct{})
+	go func() {
+		if gopherserver != nil {
+			if err := gopherserver.Shutdown(ctx); err != nil {
+				log.Printf("gopher server shutdown with error: %v", err)
+			}
+		}
+		close(gopherdone)
+	}()
 	if err := server.Shutdown(ctx); err != nil {
-		cancel()
-		log.Fatalf("ListenAndServe shutdown with error: %v", err)
+		log.Printf("ListenAndServe shutdown with error: %v", err)
 	}
 
 	<-confirm
+	<-spartandone
+	<-gopherdone
 	cancel()
 }
 
-func setupCertificate(crt, key, host string, validdays int) func() (*tls.Config, error) {
+func setupHandler(host, root string, flogger *log.Logger, cache int, autoindex bool, upload *fileserver.UploadConfig) gemini.Handler {
+	mux := gemini.NewMux()
+	mux.Use(middleware.Logger(flogger, host+" "))
+	if cache > 0 {
+		mux.Use(middleware.Cache(cache))
+	}
+
+	serve := fileserver.Serve(root, autoindex)
+	if upload == nil {
+		return mux.Handle(gemini.HandlerFunc(serve))
+	}
+
+	store := fileserver.Upload(root, *upload)
+	return mux.Handle(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		if r.Titan != nil {
+			store(w, r)
+			return
+		}
+		serve(w, r)
+	}))
+}
+
+// splitList splits a comma separated flag value, empty elements are dropped.
+func splitList(list string) []string {
+	var out []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// setupErrorHandler logs protocol level failures, such as bad requests and timeouts, to the
+// access log.
+func setupErrorHandler(host string, flogger *log.Logger) gemini.Handler {
+	mux := gemini.NewMux()
+	mux.Use(middleware.Logger(flogger, host+" "))
+	return mux.Handle(gemini.HandlerFunc(gemini.HandleRequestError))
+}
+
+// setupProxyHandler logs proxied requests to the access log, prefixed with proxy instead of a
+// served host.
+func setupProxyHandler(proxy *gemini.Proxy, flogger *log.Logger) gemini.Handler {
+	mux := gemini.NewMux()
+	mux.Use(middleware.Logger(flogger, "proxy "))
+	return mux.Handle(proxy)
+}
+
+func setupCertificates(hosts []vhost, validdays int) func() (*tls.Config, error) {
 	return func() (*tls.Config, error) {
-		if crt != "" && key != "" {
-			cert, err := tls.LoadX509KeyPair(crt, key)
+		certs := make(map[string]tls.Certificate, len(hosts))
+		for _, vh := range hosts {
+			cert, err := loadCertificate(vh.crt, vh.key, vh.host, validdays)
 			if err != nil {
-				return nil, fmt.Errorf("load x509 keypair: %w", err)
+				return nil, fmt.Errorf("%s: %w", vh.host, err)
 			}
-			return gemini.TLSConfig(host, cert), nil
+			certs[vh.host] = cert
 		}
 
-		// only used for testing
-		log.Printf("generating a self-signed temporary certificate, valid for %d days\n", validdays)
-		cert, err := gemini.GenX509KeyPair(host, validdays)
+		if len(hosts) == 1 {
+			return gemini.TLSConfig(hosts[0].host, certs[hosts[0].host]), nil
+		}
+		return gemini.HostsTLSConfig(hosts[0].host, certs), nil
+	}
+}
+
+func loadCertificate(crt, key, host string, validdays int) (tls.Certificate, error) {
+	if crt != "" && key != "" {
+		cert, err := tls.LoadX509KeyPair(crt, key)
 		if err != nil {
-			return nil, fmt.Errorf("generate x509 keypair: %w", err)
+			return cert, fmt.Errorf("load x509 keypair: %w", err)
 		}
-		return gemini.TLSConfig(host, cert), nil
+		return cert, nil
+	}
+
+	// only used for testing
+	log.Printf("generating a self-signed temporary certificate for %s, valid for %d days\n", host, validdays)
+	cert, err := gemini.GenX509KeyPair(host, validdays)
+	if err != nil {
+		return cert, fmt.Errorf("generate x509 keypair: %w", err)
 	}
+	return cert, nil
 }
 
 func setupLogger(dir, filename string) (*log.Logger, error) {
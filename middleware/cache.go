@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"path"
+	"strings"
 	"sync"
 
 	"github.com/n0x1m/gmifs/gemini"
@@ -53,7 +55,13 @@ func (c *cache) Write(key string, mimeType string, doc []byte) {
 	c.Unlock()
 }
 
+// Cache is a fifo document cache for n success responses. Responses pass through unbuffered if n
+// is zero or less.
 func Cache(n int) func(next gemini.Handler) gemini.Handler {
+	if n <= 0 {
+		return func(next gemini.Handler) gemini.Handler { return next }
+	}
+
 	return (&cache{
 		size:      n,
 		documents: make(map[string][]byte, n+1),
@@ -62,11 +70,36 @@ func Cache(n int) func(next gemini.Handler) gemini.Handler {
 	}).middleware
 }
 
+// Delete removes the document at path.
+func (c *cache) Delete(path string) {
+	c.Lock()
+	delete(c.documents, path)
+	delete(c.mimeTypes, path)
+	c.Unlock()
+}
+
 func (c *cache) middleware(next gemini.Handler) gemini.Handler {
 	fn := func(w gemini.ResponseWriter, r *gemini.Request) {
+		// uploads are never cached and invalidate the previous document.
+		if r.Titan != nil {
+			next.ServeGemini(w, r)
+			c.Delete(r.URL.Path)
+			if dir, file := path.Split(r.URL.Path); file == gemini.IndexFile {
+				c.Delete(dir)
+				c.Delete(strings.TrimSuffix(dir, "/"))
+			}
+			return
+		}
+
+		// responses to input differ per query, every query would take a slot of its own.
+		if r.URL.RawQuery != "" {
+			next.ServeGemini(w, r)
+			return
+		}
+
 		key := r.URL.Path
 		if body, mimeType, hit := c.Read(key); hit {
-			w.WriteHeader(gemini.StatusSuccess, mimeType)
+			gemini.Success(w, mimeType)
 			w.Write(body)
 
 			return
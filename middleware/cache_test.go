@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+func TestCache(t *testing.T) {
+	calls := 0
+	handler := Cache(2)(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		calls++
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(gemini.StatusNotFound, "not found")
+			return
+		}
+		w.WriteHeader(gemini.StatusSuccess, "text/gemini")
+		w.Write([]byte("# " + r.URL.Path))
+	}))
+
+	for i := 0; i < 2; i++ {
+		rec := geminitest.NewRecorder()
+		handler.ServeGemini(rec, geminitest.NewRequest("gemini://localhost/doc"))
+		if rec.Code != gemini.StatusSuccess || rec.Meta != "text/gemini" || rec.Body.String() != "# /doc" {
+			t.Fatalf("request %d: got %q %q", i, rec.Header(), rec.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("cached document: handler called %d times, want 1", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := geminitest.NewRecorder()
+		handler.ServeGemini(rec, geminitest.NewRequest("gemini://localhost/missing"))
+		if rec.Code != gemini.StatusNotFound {
+			t.Fatalf("request %d: got %q", i, rec.Header())
+		}
+	}
+	if calls != 3 {
+		t.Errorf("failure response: handler called %d times, want 3", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := geminitest.NewRecorder()
+		handler.ServeGemini(rec, geminitest.NewRequest("gemini://localhost/doc?input"))
+		if rec.Code != gemini.StatusSuccess {
+			t.Fatalf("request %d: got %q", i, rec.Header())
+		}
+	}
+	if calls != 5 {
+		t.Errorf("query: handler called %d times, want 5", calls)
+	}
+
+	// queries bypass the cache and don't evict documents
+	handler.ServeGemini(geminitest.NewRecorder(), geminitest.NewRequest("gemini://localhost/doc"))
+	if calls != 5 {
+		t.Errorf("document after query: handler called %d times, want 5", calls)
+	}
+
+	upload := geminitest.NewRequest("gemini://localhost/doc")
+	upload.Titan = &gemini.Titan{Size: 1}
+	handler.ServeGemini(geminitest.NewRecorder(), upload)
+	handler.ServeGemini(geminitest.NewRecorder(), geminitest.NewRequest("gemini://localhost/doc"))
+	if calls != 7 {
+		t.Errorf("upload: handler called %d times, want 7", calls)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	next := gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		if _, ok := w.(gemini.Flusher); !ok {
+			t.Error("response writer is wrapped")
+		}
+		gemini.Success(w, "")
+	})
+
+	rec := geminitest.NewRecorder()
+	Cache(0)(next).ServeGemini(rec, geminitest.NewRequest("gemini://localhost/"))
+	if rec.Code != gemini.StatusSuccess {
+		t.Errorf("got %q", rec.Header())
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// ClientCert requires a client certificate for all requests passing through. Requests without a
+// certificate are answered with 60, expired or not yet valid certificates with 62 and certificates
+// rejected by authorize with 61. A nil authorize function accepts any valid certificate.
+func ClientCert(authorize func(*x509.Certificate) bool) func(gemini.Handler) gemini.Handler {
+	return func(next gemini.Handler) gemini.Handler {
+		fn := func(w gemini.ResponseWriter, r *gemini.Request) {
+			cert := r.Certificate()
+			if cert == nil {
+				gemini.CertificateRequired(w, "client certificate required")
+				return
+			}
+
+			if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+				w.WriteHeader(gemini.StatusCertificateNotValid, "certificate not valid")
+				return
+			}
+
+			if authorize != nil && !authorize(cert) {
+				w.WriteHeader(gemini.StatusCertificateNotAuthorized, "certificate not authorized")
+				return
+			}
+
+			next.ServeGemini(w, r)
+		}
+		return gemini.HandlerFunc(fn)
+	}
+}
+
+// Fingerprints returns an authorize function for ClientCert that accepts certificates by their
+// SHA-256 fingerprint. Fingerprints are compared case insensitive, colons are ignored.
+func Fingerprints(fingerprints ...string) func(*x509.Certificate) bool {
+	allowed := make(map[string]struct{}, len(fingerprints))
+	for _, fp := range fingerprints {
+		allowed[normalizeFingerprint(fp)] = struct{}{}
+	}
+
+	return func(cert *x509.Certificate) bool {
+		_, ok := allowed[gemini.Fingerprint(cert)]
+		return ok
+	}
+}
+
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+}
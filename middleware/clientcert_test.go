@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+func TestClientCert(t *testing.T) {
+	cert, err := gemini.GenX509KeyPair("client", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+		w.WriteHeader(gemini.StatusSuccess, "text/gemini")
+	})
+	mux := gemini.NewMux()
+	mux.Use(ClientCert(Fingerprints(gemini.Fingerprint(leaf))))
+	ts := geminitest.NewUnstartedServer(mux.Handle(ok))
+	ts.Config.RequestClientCerts = true
+	ts.Start()
+	defer ts.Close()
+
+	other, err := gemini.GenX509KeyPair("other", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+		want  int
+	}{
+		{"none", nil, gemini.StatusClientCertificateRequired},
+		{"authorized", []tls.Certificate{cert}, gemini.StatusSuccess},
+		{"unauthorized", []tls.Certificate{other}, gemini.StatusCertificateNotAuthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := ts.Client()
+			client.Certificates = tt.certs
+
+			rsp, err := client.Get(ts.URL + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsp.Body.Close()
+
+			if rsp.Status != tt.want {
+				t.Errorf("got status %d %q, want %d", rsp.Status, rsp.Meta, tt.want)
+			}
+		})
+	}
+}
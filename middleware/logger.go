@@ -3,7 +3,7 @@ package middleware
 import (
 	"fmt"
 	"log"
-	"strings"
+	"net"
 	"time"
 
 	"github.com/n0x1m/gmifs/gemini"
@@ -14,21 +14,53 @@ func Logger(log *log.Logger, prefix string) func(gemini.Handler) gemini.Handler
 		fn := func(w gemini.ResponseWriter, r *gemini.Request) {
 			t := time.Now()
 
-			ri := gemini.NewInterceptor(w)
-			next.ServeGemini(ri, r)
-			ri.Flush()
+			lw := &loggingWriter{ResponseWriter: w}
+			next.ServeGemini(lw, r)
 
-			ip := strings.Split(r.RemoteAddr, ":")[0]
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
 			fmt.Fprintf(log.Writer(), "%s%s - - [%s] \"%s\" %d %d - %v\n",
 				prefix,
 				ip,
 				t.Format("02/Jan/2006:15:04:05 -0700"),
 				r.URL.Path,
-				ri.Code,
-				ri.Body.Len(),
+				lw.code,
+				lw.size,
 				time.Since(t),
 			)
 		}
 		return gemini.HandlerFunc(fn)
 	}
 }
+
+// loggingWriter records status code and body size while passing writes through, so that streamed
+// responses are not buffered for logging.
+type loggingWriter struct {
+	gemini.ResponseWriter
+	code int
+	size int
+}
+
+func (lw *loggingWriter) WriteHeader(code int, message string) (int, error) {
+	n, err := lw.ResponseWriter.WriteHeader(code, message)
+	if err == nil {
+		lw.code = code
+	}
+	return n, err
+}
+
+func (lw *loggingWriter) Write(body []byte) (int, error) {
+	n, err := lw.ResponseWriter.Write(body)
+	lw.size += n
+	return n, err
+}
+
+// Flush passes through to the underlying writer if it supports flushing.
+func (lw *loggingWriter) Flush() error {
+	if f, ok := lw.ResponseWriter.(gemini.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
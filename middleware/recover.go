@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"runtime/debug"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// Recover recovers from panics in the next handler and answers with 40 unless a header was
+// already written. If report is set, it is called with the request, the panic value and the
+// stack, e.g. to count panics or to log them along with a request ID.
+func Recover(report func(r *gemini.Request, v interface{}, stack []byte)) func(gemini.Handler) gemini.Handler {
+	return func(next gemini.Handler) gemini.Handler {
+		fn := func(w gemini.ResponseWriter, r *gemini.Request) {
+			rw := &recoverWriter{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+
+				if report != nil {
+					report(r, v, debug.Stack())
+				}
+
+				if !rw.wroteHeader {
+					w.WriteHeader(gemini.StatusTemporaryFailure, "internal server error")
+				}
+			}()
+
+			next.ServeGemini(rw, r)
+		}
+		return gemini.HandlerFunc(fn)
+	}
+}
+
+// recoverWriter tracks whether the header was written.
+type recoverWriter struct {
+	gemini.ResponseWriter
+	wroteHeader bool
+}
+
+func (rw *recoverWriter) WriteHeader(code int, message string) (int, error) {
+	n, err := rw.ResponseWriter.WriteHeader(code, message)
+	if err == nil {
+		rw.wroteHeader = true
+	}
+	return n, err
+}
+
+// Flush passes through to the underlying writer if it supports flushing.
+func (rw *recoverWriter) Flush() error {
+	if f, ok := rw.ResponseWriter.(gemini.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
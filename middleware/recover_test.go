@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+	"github.com/n0x1m/gmifs/geminitest"
+)
+
+func TestRecover(t *testing.T) {
+	var reported interface{}
+	report := func(r *gemini.Request, v interface{}, stack []byte) {
+		reported = v
+		if len(stack) == 0 {
+			t.Error("report without stack")
+		}
+	}
+
+	tests := []struct {
+		name   string
+		header bool
+		want   int
+	}{
+		{"before header", false, gemini.StatusTemporaryFailure},
+		{"after header", true, gemini.StatusSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reported = nil
+			handler := Recover(report)(gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+				if tt.header {
+					w.WriteHeader(gemini.StatusSuccess, "text/gemini")
+				}
+				panic("boom")
+			}))
+
+			rec := geminitest.NewRecorder()
+			handler.ServeGemini(rec, geminitest.NewRequest("gemini://localhost/"))
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d", rec.Code, tt.want)
+			}
+			if reported != "boom" {
+				t.Errorf("reported %v, want boom", reported)
+			}
+		})
+	}
+}
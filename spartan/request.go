@@ -0,0 +1,85 @@
+package spartan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// requestMaxBytes bounds the request line, a host of up to 255 bytes and a path as long as a
+// gemini URL.
+const requestMaxBytes = 255 + 1 + gemini.URLMaxBytes + 1 + 20
+
+// ParseRequest reads a spartan request from r and maps it onto a gemini request:
+//
+//	<host><SP><path><SP><content-length><CR><LF><data>
+//
+// The URL of the returned request has the spartan scheme, the host and the path. Data, the input
+// of spartan prompts, is read up to maxInput bytes and becomes the percent-encoded query, so that
+// handlers read it with Request.Input. Errors are of type *gemini.GmiError with a gemini status.
+func ParseRequest(r io.Reader, maxInput int64) (*gemini.Request, error) {
+	line, err := gemini.ReadLine(r, requestMaxBytes, true)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &gemini.Request{RequestURI: line}
+	fields := strings.Split(line, " ")
+	if len(fields) != 3 || !utf8.ValidString(line) {
+		return req, gemini.Error(gemini.StatusBadRequest, ErrInvalidRequest)
+	}
+
+	host, rawpath := fields[0], fields[1]
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || size < 0 {
+		return req, gemini.Error(gemini.StatusBadRequest, ErrInvalidRequest)
+	} else if host == "" || strings.ContainsAny(host, "/@:") {
+		return req, gemini.Error(gemini.StatusBadRequest, gemini.ErrInvalidHost)
+	} else if !strings.HasPrefix(rawpath, "/") {
+		return req, gemini.Error(gemini.StatusBadRequest, gemini.ErrInvalidPath)
+	}
+
+	u, err := url.Parse("spartan://" + host + rawpath)
+	if err != nil || u.Fragment != "" {
+		return req, gemini.Error(gemini.StatusBadRequest, ErrInvalidRequest)
+	}
+	if cleaned := path.Clean(u.Path); cleaned != u.Path && cleaned != strings.TrimRight(u.Path, "/") {
+		return req, gemini.Error(gemini.StatusBadRequest, gemini.ErrInvalidPath)
+	}
+	req.URL = u
+
+	if size > 0 {
+		if size > maxInput {
+			return req, gemini.Error(gemini.StatusBadRequest, ErrInputTooLarge)
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(r, size))
+		if err != nil {
+			return req, readError(err)
+		} else if int64(len(data)) != size {
+			return req, gemini.Error(gemini.StatusBadRequest, ErrShortInput)
+		}
+		u.RawQuery = url.PathEscape(string(data))
+	}
+
+	return req, nil
+}
+
+func readError(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return gemini.Error(gemini.StatusServerUnavailable, gemini.ErrReadTimeout)
+	} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return gemini.Error(gemini.StatusBadRequest, gemini.ErrMissingTermination)
+	}
+	return fmt.Errorf("spartan: read request: %w", err)
+}
@@ -0,0 +1,152 @@
+// Package spartan serves the spartan protocol with gemini handlers. Requests are mapped onto
+// gemini requests and responses are translated to spartan status codes, so that the fileserver
+// and the middlewares work unchanged.
+package spartan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// DefaultPort is the registered spartan port.
+const DefaultPort = 300
+
+// DefaultMaxInputSize is the input data limit if Server.MaxInputSize is zero.
+const DefaultMaxInputSize = 1024
+
+var (
+	ErrInvalidRequest = errors.New("spartan: invalid request line")
+	ErrInputTooLarge  = errors.New("spartan: input data too large")
+	ErrShortInput     = errors.New("spartan: input data shorter than announced")
+)
+
+// Server serves spartan over plain TCP.
+type Server struct {
+	// Addr is the TCP address to listen on, ":300" if empty.
+	Addr string
+
+	// Hostname of the server. If set, requests for other hosts are refused with 4.
+	Hostname string
+
+	// Handler is the gemini handler tree to serve, see the package doc.
+	Handler gemini.Handler
+
+	// ErrorHandler answers requests that fail before reaching Handler with a synthetic request
+	// with Err set, like gemini.Server.ErrorHandler. Defaults to gemini.HandleRequestError.
+	ErrorHandler gemini.Handler
+
+	// Logger receives leveled server events, logging is disabled if nil.
+	Logger gemini.Logger
+
+	// ReadTimeout bounds reading the request including its data, WriteTimeout every single
+	// write. HandlerTimeout cancels the request context. Zero means no timeout.
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	HandlerTimeout time.Duration
+
+	// MaxInputSize limits the request data, DefaultMaxInputSize if zero.
+	MaxInputSize int64
+
+	// MaxOpenConns limits the number of connections handled concurrently, zero means no limit.
+	// Clients beyond the limit wait in the listen backlog.
+	MaxOpenConns int
+
+	conns gemini.ConnServer
+}
+
+func (s *Server) log(level gemini.Level, msg string, keyvals ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Log(level, msg, keyvals...)
+	}
+}
+
+// ListenAndServe listens on s.Addr and then serves it.
+func (s *Server) ListenAndServe() error {
+	if s.conns.ShuttingDown() {
+		return gemini.ErrServerClosed
+	}
+
+	addr := s.Addr
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", DefaultPort)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("spartan server listen: %w", err)
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves them with the handler. Serve always returns a
+// non-nil error and closes l. After Shutdown the returned error is gemini.ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.conns.Logger = s.Logger
+	s.conns.ErrorHandler = s.ErrorHandler
+	s.conns.MaxOpenConns = s.MaxOpenConns
+	s.conns.HandlerTimeout = s.HandlerTimeout
+
+	err := s.conns.Serve(l, s.handleConnection)
+	if errors.Is(err, gemini.ErrServerClosed) {
+		return err
+	}
+	return fmt.Errorf("spartan server accept: %w", err)
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	w := newWriter(conn, s.WriteTimeout)
+	defer w.Flush()
+
+	req, err := s.readRequest(conn)
+	if req != nil {
+		w.url = req.URL
+	}
+	if err == nil && s.Hostname != "" && !strings.EqualFold(req.URL.Hostname(), s.Hostname) {
+		err = gemini.Error(gemini.StatusProxyRequestRefused, gemini.ErrForeignHost)
+	}
+	if err != nil {
+		if errors.Is(err, gemini.ErrEmptyRequest) {
+			return
+		}
+		s.log(gemini.LevelDebug, "spartan request error", "error", err, "remote", conn.RemoteAddr())
+		s.conns.ServeError(w, conn, req, err)
+		return
+	}
+
+	ctx, cancel := s.conns.RequestContext()
+	defer cancel()
+
+	req = req.WithContext(ctx)
+	req.RemoteAddr = conn.RemoteAddr().String()
+
+	s.conns.ServeRequest(w, req, s.Handler)
+}
+
+// readRequest reads the request line and data within the read timeout.
+func (s *Server) readRequest(conn net.Conn) (*gemini.Request, error) {
+	if s.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	maxInput := s.MaxInputSize
+	if maxInput == 0 {
+		maxInput = DefaultMaxInputSize
+	}
+	return ParseRequest(conn, maxInput)
+}
+
+// Shutdown stops accepting new connections, cancels the request contexts and waits for in-flight
+// requests to finish. If the context deadline passes first, the remaining connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.conns.Shutdown(ctx); err != nil {
+		return fmt.Errorf("spartan: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package spartan
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		url    string
+		input  string
+		status int // zero for a valid request
+	}{
+		{name: "Homepage", raw: "localhost / 0\r\n", url: "spartan://localhost/"},
+		{name: "Path", raw: "localhost /docs/index.gmi 0\r\n", url: "spartan://localhost/docs/index.gmi"},
+		{name: "Input", raw: "localhost /search 11\r\nhello world", url: "spartan://localhost/search?hello%20world",
+			input: "hello world"},
+		{name: "InputLiteralPlus", raw: "localhost /calc 5\r\n1+1=2", url: "spartan://localhost/calc?1+1=2", input: "1+1=2"},
+		{name: "Empty", raw: "\r\n", status: gemini.StatusBadRequest},
+		{name: "MissingSize", raw: "localhost /\r\n", status: gemini.StatusBadRequest},
+		{name: "NegativeSize", raw: "localhost / -1\r\n", status: gemini.StatusBadRequest},
+		{name: "RelativePath", raw: "localhost index.gmi 0\r\n", status: gemini.StatusBadRequest},
+		{name: "DotEscape", raw: "localhost /../../etc 0\r\n", status: gemini.StatusBadRequest},
+		{name: "HostWithPort", raw: "localhost:300 / 0\r\n", status: gemini.StatusBadRequest},
+		{name: "MissingTermination", raw: "localhost / 0\n", status: gemini.StatusBadRequest},
+		{name: "ShortInput", raw: "localhost / 5\r\nabc", status: gemini.StatusBadRequest},
+		{name: "InputTooLarge", raw: "localhost / 1025\r\n", status: gemini.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseRequest(strings.NewReader(tt.raw), DefaultMaxInputSize)
+			if tt.status != 0 {
+				if gmierr, ok := err.(*gemini.GmiError); !ok || gmierr.Code != tt.status {
+					t.Fatalf("expected status %d, got %v", tt.status, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.URL.String() != tt.url {
+				t.Errorf("expected url %q, got %q", tt.url, req.URL)
+			}
+			if input, _ := req.Input(); input != tt.input {
+				t.Errorf("expected input %q, got %q", tt.input, input)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	reqURL, _ := url.Parse("spartan://localhost/docs/page.gmi")
+	tests := []struct {
+		code   int
+		meta   string
+		status int
+		want   string
+	}{
+		{gemini.StatusSuccess, "text/gemini", StatusSuccess, "text/gemini"},
+		{gemini.StatusInput, "query?", StatusClientError, "query?"},
+		{gemini.StatusRedirectTemporary, "other.gmi", StatusRedirect, "/docs/other.gmi"},
+		{gemini.StatusRedirectPermanent, "/new?q", StatusRedirect, "/new?q"},
+		{gemini.StatusRedirectTemporary, "gemini://localhost/new", StatusRedirect, "/new"},
+		{gemini.StatusRedirectTemporary, "gemini://example.org/", StatusServerError, "redirect to another host"},
+		{gemini.StatusSlowDown, "5", StatusServerError, "5"},
+		{gemini.StatusNotFound, "not found", StatusClientError, "not found"},
+		{gemini.StatusClientCertificateRequired, "login", StatusClientError, "login"},
+	}
+
+	for _, tt := range tests {
+		status, meta := Status(tt.code, tt.meta, reqURL)
+		if status != tt.status || meta != tt.want {
+			t.Errorf("Status(%d, %q): got %d %q, want %d %q", tt.code, tt.meta, status, meta, tt.status, tt.want)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		Hostname: "localhost",
+		Handler: gemini.HandlerFunc(func(w gemini.ResponseWriter, r *gemini.Request) {
+			switch r.URL.Path {
+			case "/panic":
+				panic("boom")
+			case "/moved":
+				gemini.Redirect(w, "/")
+				return
+			}
+			input, _ := r.Input()
+			gemini.Success(w, "text/plain")
+			w.Write([]byte(r.URL.Path + " " + input))
+		}),
+	}
+	go s.Serve(l)
+	defer s.Shutdown(context.Background())
+
+	tests := []struct {
+		request string
+		want    string
+	}{
+		{"localhost /page 0\r\n", "2 text/plain\r\n/page "},
+		{"localhost /search 4\r\ntest", "2 text/plain\r\n/search test"},
+		{"localhost /moved 0\r\n", "3 /\r\n"},
+		{"localhost /panic 0\r\n", "5 gemini: internal server error\r\n"},
+		{"example.org / 0\r\n", "4 gemini: host not served\r\n"},
+	}
+
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.Write([]byte(tt.request))
+		rsp, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rsp) != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.request, rsp, tt.want)
+		}
+	}
+}
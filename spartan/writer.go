@@ -0,0 +1,74 @@
+package spartan
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/n0x1m/gmifs/gemini"
+)
+
+// Spartan status codes.
+const (
+	StatusSuccess     = 2
+	StatusRedirect    = 3
+	StatusClientError = 4
+	StatusServerError = 5
+)
+
+// Status translates a gemini status and meta to a spartan status and meta. Redirects are
+// resolved against the request URL, spartan only redirects to absolute paths on the same host,
+// redirects to gemini URLs of the same host keep the path.
+// Input prompts and client certificate requests have no spartan counterpart and are client
+// errors.
+func Status(code int, meta string, reqURL *url.URL) (int, string) {
+	switch code / 10 {
+	case 1:
+		return StatusClientError, meta
+	case 2:
+		return StatusSuccess, meta
+	case 3:
+		target, err := url.Parse(meta)
+		if err != nil {
+			return StatusServerError, "invalid redirect"
+		}
+		if reqURL != nil {
+			target = reqURL.ResolveReference(target)
+		}
+		if target.IsAbs() && (reqURL == nil || !strings.EqualFold(target.Hostname(), reqURL.Hostname())) {
+			return StatusServerError, "redirect to another host"
+		}
+		return StatusRedirect, target.RequestURI()
+	case 4:
+		return StatusServerError, meta
+	default:
+		return StatusClientError, meta
+	}
+}
+
+// writer translates gemini headers to spartan and buffers writes like the gemini server.
+type writer struct {
+	*gemini.ConnWriter
+	url         *url.URL
+	wroteHeader bool
+}
+
+func newWriter(conn net.Conn, timeout time.Duration) *writer {
+	return &writer{ConnWriter: gemini.NewConnWriter(conn, timeout)}
+}
+
+func (w *writer) WriteHeader(code int, message string) (int, error) {
+	if w.wroteHeader {
+		return 0, gemini.ErrHeaderWritten
+	}
+	if err := gemini.ValidateHeader(code, message); err != nil {
+		return 0, err
+	}
+	w.wroteHeader = true
+
+	// <STATUS><SPACE><META><CR><LF>
+	status, meta := Status(code, message, w.url)
+	return w.Write([]byte(fmt.Sprintf("%d %s%s", status, meta, gemini.Termination)))
+}
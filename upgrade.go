@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// environment variables to hand the listener and the readiness pipe to an upgraded binary
+	envListenFd  = "GMIFS_LISTEN_FD"
+	envReadyFd   = "GMIFS_READY_FD"
+	envSpartanFd = "GMIFS_SPARTAN_FD"
+	envGopherFd  = "GMIFS_GOPHER_FD"
+
+	upgradeReadyTimeout = 30 * time.Second
+)
+
+var errUpgradeNotReady = errors.New("new process exited before reporting ready")
+
+// upgrade starts the executable at the current path, which may have been replaced by a new build,
+// with the same arguments and passes it the listening sockets. Additional listeners are passed
+// in the environment variable they are keyed by, nil listeners are skipped. It returns once the
+// new process reported ready, the caller is then expected to drain and exit.
+func upgrade(l net.Listener, extra map[string]net.Listener) error {
+	lf, err := listenerFile(l)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+
+	ready, readyw, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("upgrade: ready pipe: %w", err)
+	}
+	defer ready.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		readyw.Close()
+		return fmt.Errorf("upgrade: executable: %w", err)
+	}
+
+	// ExtraFiles start at fd 3
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{lf, readyw}
+	cmd.Env = append(upgradeEnv(), envListenFd+"=3", envReadyFd+"=4")
+	envs := make([]string, 0, len(extra))
+	for env, el := range extra {
+		if el != nil {
+			envs = append(envs, env)
+		}
+	}
+	sort.Strings(envs)
+
+	for _, env := range envs {
+		ef, err := listenerFile(extra[env])
+		if err != nil {
+			readyw.Close()
+			return err
+		}
+		defer ef.Close()
+
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", env, 3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, ef)
+	}
+
+	err = cmd.Start()
+	readyw.Close()
+	if err != nil {
+		return fmt.Errorf("upgrade: start: %w", err)
+	}
+
+	// the child writes a byte once it serves, EOF means it exited early.
+	result := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		if err != nil {
+			err = errUpgradeNotReady
+		}
+		result <- err
+	}()
+
+	select {
+	case err = <-result:
+	case <-time.After(upgradeReadyTimeout):
+		err = fmt.Errorf("new process not ready after %v", upgradeReadyTimeout)
+	}
+
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("upgrade: %w", err)
+	}
+
+	// the socket file belongs to the new process now
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+
+	return cmd.Process.Release()
+}
+
+// listenerFile duplicates the listening socket for handoff.
+func listenerFile(l net.Listener) (*os.File, error) {
+	fl, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("upgrade: listener %T does not support fd handoff", l)
+	}
+
+	f, err := fl.File()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: listener file: %w", err)
+	}
+	return f, nil
+}
+
+// upgradeEnv returns the environment without inherited listener variables.
+func upgradeEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case envListenFd, envReadyFd, envSpartanFd, envGopherFd, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES":
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// upgradeListener returns the listener handed over by a previous gmifs process or nil if there
+// is none.
+func upgradeListener() (net.Listener, error) {
+	return inheritedListener(envListenFd)
+}
+
+// inheritedListener returns the listener at the fd in the environment variable env or nil if
+// it is not set.
+func inheritedListener(env string) (net.Listener, error) {
+	fd, err := strconv.Atoi(os.Getenv(env))
+	if err != nil {
+		return nil, nil
+	}
+	os.Unsetenv(env)
+
+	f := os.NewFile(uintptr(fd), "gmifs-listener")
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited listener: %w", err)
+	}
+	return l, nil
+}
+
+// notifyReady tells the previous gmifs process that this process took over, if there is one.
+func notifyReady() error {
+	fd, err := strconv.Atoi(os.Getenv(envReadyFd))
+	if err != nil {
+		return nil
+	}
+	os.Unsetenv(envReadyFd)
+
+	f := os.NewFile(uintptr(fd), "gmifs-ready")
+	defer f.Close()
+
+	if _, err := f.Write([]byte{1}); err != nil {
+		return fmt.Errorf("notify ready: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errInvalidVhost = errors.New("expected hostname,root[,cert,key]")
+
+type vhost struct {
+	host, root, crt, key string
+}
+
+// vhostFlag collects repeated -vhost flags.
+type vhostFlag []vhost
+
+func (v *vhostFlag) String() string {
+	hosts := make([]string, 0, len(*v))
+	for _, vh := range *v {
+		hosts = append(hosts, vh.host)
+	}
+	return strings.Join(hosts, " ")
+}
+
+func (v *vhostFlag) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if (len(parts) != 2 && len(parts) != 4) || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("vhost %q: %w", value, errInvalidVhost)
+	}
+
+	vh := vhost{host: parts[0], root: parts[1]}
+	if len(parts) == 4 {
+		vh.crt, vh.key = parts[2], parts[3]
+	}
+
+	*v = append(*v, vh)
+	return nil
+}